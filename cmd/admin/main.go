@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/utils"
+	"auth-service/pkg/database"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg := config.LoadConfig()
+	if err := utils.ConfigureArgon(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	switch os.Args[1] {
+	case "rotate-keys":
+		rotateKeys(cfg, os.Args[2:])
+	case "create-client":
+		createClient(cfg, os.Args[2:])
+	case "pepper-usage":
+		pepperUsage(cfg)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: admin <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  rotate-keys    add a new signing key to the access and/or refresh key ring")
+	fmt.Fprintln(os.Stderr, "  create-client  register an OAuth client and print its credentials")
+	fmt.Fprintln(os.Stderr, "  pepper-usage   count the password, history and client secret hashes per pepper version")
+	os.Exit(2)
+}
+
+func rotateKeys(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	ring := fs.String("ring", "all", "key ring to rotate: access, refresh or all")
+	now := fs.Bool("now", false, "activate the new key immediately instead of after JWT_KEY_ACTIVATION_DELAY")
+	fs.Parse(args)
+
+	if cfg.JWTKeysDir == "" {
+		log.Fatal("JWT_KEYS_DIR must be set to rotate keys")
+	}
+
+	keys, err := utils.LoadTokenKeys(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+
+	rotated := false
+	for _, rotator := range utils.NewKeyRotators(cfg, keys) {
+		if *ring != "all" && *ring != rotator.Ring {
+			continue
+		}
+		key, err := rotator.Rotate(*now)
+		if err != nil {
+			log.Fatalf("Failed to rotate %s key ring: %v", rotator.Ring, err)
+		}
+		log.Printf("Added %s key %s (%s), active from %s", rotator.Ring, key.ID, key.Method.Alg(), key.ActivatesAt.Format("2006-01-02 15:04:05 MST"))
+		rotated = true
+	}
+	if !rotated {
+		log.Fatalf("Unknown key ring: %s", *ring)
+	}
+}
+
+func createClient(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("create-client", flag.ExitOnError)
+	name := fs.String("name", "", "display name of the client")
+	scopes := fs.String("scopes", "", "space separated scopes the client may request")
+	redirectURIs := fs.String("redirect-uris", "", "space separated redirect URIs for the authorization code flow")
+	grantTypes := fs.String("grant-types", "authorization_code refresh_token", "space separated grant types the client may use")
+	public := fs.Bool("public", false, "public client without a secret")
+	fs.Parse(args)
+
+	if *name == "" {
+		log.Fatal("-name is required")
+	}
+
+	client := &models.Client{
+		ClientID:     utils.NewID(),
+		Name:         *name,
+		RedirectURIs: *redirectURIs,
+		Scopes:       *scopes,
+		GrantTypes:   *grantTypes,
+	}
+
+	var secret string
+	if !*public {
+		var err error
+		secret, err = utils.GenerateSecret(32)
+		if err != nil {
+			log.Fatalf("Failed to generate client secret: %v", err)
+		}
+		client.SecretHash, err = utils.HashSecret(secret)
+		if err != nil {
+			log.Fatalf("Failed to hash client secret: %v", err)
+		}
+	}
+
+	database.ConnectPostgres(cfg)
+	if err := repository.NewClientRepository(database.DB).CreateClient(client); err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+
+	fmt.Printf("client_id:     %s\n", client.ClientID)
+	if secret != "" {
+		fmt.Printf("client_secret: %s\n", secret)
+		fmt.Println("The secret is not stored in clear text and cannot be shown again.")
+	}
+}
+
+func pepperUsage(cfg *config.Config) {
+	database.ConnectPostgres(cfg)
+	userRepo := repository.NewUserRepository(database.DB)
+	users, err := userRepo.CountPasswordPeppers()
+	if err != nil {
+		log.Fatalf("Failed to count password hashes: %v", err)
+	}
+	history, err := userRepo.CountHistoryPeppers()
+	if err != nil {
+		log.Fatalf("Failed to count password history hashes: %v", err)
+	}
+	clients, err := repository.NewClientRepository(database.DB).CountSecretPeppers()
+	if err != nil {
+		log.Fatalf("Failed to count client secret hashes: %v", err)
+	}
+
+	keyIDs := map[string]bool{}
+	for keyID := range users {
+		keyIDs[keyID] = true
+	}
+	for keyID := range history {
+		keyIDs[keyID] = true
+	}
+	for keyID := range clients {
+		keyIDs[keyID] = true
+	}
+	for _, version := range utils.PepperVersions() {
+		keyIDs[strconv.Itoa(version)] = true
+	}
+	sorted := make([]string, 0, len(keyIDs))
+	for keyID := range keyIDs {
+		sorted = append(sorted, keyID)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, _ := strconv.Atoi(sorted[i])
+		b, _ := strconv.Atoi(sorted[j])
+		return a < b
+	})
+
+	fmt.Printf("%-8s %10s %10s %10s\n", "keyid", "users", "history", "clients")
+	for _, keyID := range sorted {
+		label := keyID
+		if label == "" {
+			label = "none"
+		}
+		fmt.Printf("%-8s %10d %10d %10d\n", label, users[keyID], history[keyID], clients[keyID])
+	}
+	fmt.Println("A version used by no hash can be removed from PASSWORD_PEPPERS.")
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
+	"auth-service/internal/breach"
 	"auth-service/internal/config"
+	"auth-service/internal/events"
 	"auth-service/internal/handlers"
+	"auth-service/internal/mailer"
 	"auth-service/internal/repository"
 	"auth-service/internal/routes"
 	"auth-service/internal/services"
+	"auth-service/internal/sms"
+	"auth-service/internal/utils"
 	"auth-service/pkg/database"
 
 	"github.com/gin-gonic/gin"
@@ -16,23 +23,89 @@ import (
 func main() {
 	// Load Config
 	cfg := config.LoadConfig()
+	if err := utils.ConfigureArgon(cfg); err != nil {
+		log.Fatal(err)
+	}
 
 	// Connect to Database
 	database.ConnectPostgres(cfg)
 	database.ConnectRedis(cfg)
 
-	// Setup Repository and Services
+	// Load Signing Keys
+	keys, err := utils.LoadTokenKeys(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	if cfg.JWTKeysDir != "" {
+		for _, rotator := range utils.NewKeyRotators(cfg, keys) {
+			if err := rotator.Sync(); err != nil {
+				log.Fatalf("Failed to sync %s key ring: %v", rotator.Ring, err)
+			}
+			go rotator.Run(context.Background(), time.Minute)
+		}
+	}
+
 	// Setup Repository and Services
 	userRepo := repository.NewUserRepository(database.DB)
 	authRepo := repository.NewAuthRepository(database.Rdb)
-	authService := services.NewAuthService(userRepo, authRepo, cfg)
+	clientRepo := repository.NewClientRepository(database.DB)
+	oauthRepo := repository.NewOAuthRepository(database.Rdb)
+	mfaRepo := repository.NewMFARepository(database.DB)
+	challengeRepo := repository.NewChallengeRepository(database.Rdb)
+	publisher := events.NewRedisPublisher(database.Rdb)
+	var corpus breach.Corpus
+	if cfg.BreachedPasswordsDir != "" {
+		if corpus, err = breach.NewDirCorpus(cfg.BreachedPasswordsDir); err != nil {
+			log.Fatalf("Failed to open breached password corpus: %v", err)
+		}
+	}
+	policy := services.NewPasswordPolicy(userRepo, corpus, cfg)
+	authService := services.NewAuthService(userRepo, authRepo, mfaRepo, challengeRepo, policy, keys, publisher, cfg)
 	authHandler := handlers.NewAuthHandler(authService)
+	webAuthnService, err := services.NewWebAuthnService(userRepo, mfaRepo, challengeRepo, authService, cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure WebAuthn: %v", err)
+	}
+	webAuthnHandler := handlers.NewWebAuthnHandler(webAuthnService)
+	var smsSender sms.Sender
+	switch {
+	case cfg.SMSWebhookURL != "":
+		smsSender = sms.NewWebhookSender(cfg.SMSWebhookURL, cfg.SMSWebhookToken)
+	case cfg.SMSLogCodes:
+		log.Println("Warning: SMS_LOG_CODES is set, SMS codes are only logged and never sent")
+		smsSender = &sms.FakeSender{}
+	default:
+		log.Println("Note: SMS_WEBHOOK_URL not set, SMS codes are disabled")
+	}
+	mfaService := services.NewMFAService(userRepo, mfaRepo, challengeRepo, authService, webAuthnService, smsSender, cfg)
+	mfaHandler := handlers.NewMFAHandler(mfaService)
+	mail := mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+	passwordlessService := services.NewPasswordlessService(userRepo, challengeRepo, authService, mail, cfg)
+	passwordlessHandler := handlers.NewPasswordlessHandler(passwordlessService)
+	passwordService := services.NewPasswordService(userRepo, challengeRepo, authService, mail, publisher, cfg)
+	passwordHandler := handlers.NewPasswordHandler(passwordService)
+	oauthService := services.NewOAuthService(clientRepo, oauthRepo, authRepo, userRepo, authService, keys, cfg)
+	oauthHandler := handlers.NewOAuthHandler(oauthService)
+	wellKnownHandler := handlers.NewWellKnownHandler(keys.Access, cfg)
 
 	// Setup Router
 	r := gin.Default()
 
 	// Setup Routes
-	routes.SetupRoutes(r, authHandler, cfg, database.Rdb)
+	routes.SetupRoutes(r, authHandler, oauthHandler, mfaHandler, webAuthnHandler, passwordlessHandler, passwordHandler, wellKnownHandler, keys.Access, database.Rdb)
+
+	// Start Metrics Server
+	if cfg.MetricsAddr != "" {
+		metricsRouter := gin.New()
+		metricsRouter.Use(gin.Recovery())
+		routes.SetupMetricsRoutes(metricsRouter, authHandler)
+		go func() {
+			log.Printf("Metrics listening on %s", cfg.MetricsAddr)
+			if err := metricsRouter.Run(cfg.MetricsAddr); err != nil {
+				log.Fatalf("Failed to run metrics server: %v", err)
+			}
+		}()
+	}
 
 	// Start Server
 	port := cfg.AppPort
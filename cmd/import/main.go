@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"auth-service/internal/config"
+	"auth-service/internal/importer"
+	"auth-service/internal/repository"
+	"auth-service/pkg/database"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: import [flags] <file.csv|file.jsonl>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Imports users with their legacy password hashes. Re-running resumes")
+		fmt.Fprintln(os.Stderr, "after the last imported batch.")
+		fmt.Fprintln(os.Stderr, "")
+		flag.PrintDefaults()
+	}
+	format := flag.String("format", "", "csv or jsonl, guessed from the file extension when empty")
+	batchSize := flag.Int("batch-size", 1000, fmt.Sprintf("users per database statement, at most %d", importer.MaxBatchSize))
+	checkpointPath := flag.String("checkpoint", "", "checkpoint file, <file>.checkpoint.json when empty")
+	rejectsPath := flag.String("rejects", "", "rejection report, <file>.rejects.jsonl when empty")
+	restart := flag.Bool("restart", false, "ignore the checkpoint and import the whole file again")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	source, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *checkpointPath == "" {
+		*checkpointPath = source + ".checkpoint.json"
+	}
+	if *rejectsPath == "" {
+		*rejectsPath = source + ".rejects.jsonl"
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(source)), ".")
+	}
+
+	checkpoint := &importer.Checkpoint{Source: source}
+	if !*restart {
+		checkpoint, err = importer.LoadCheckpoint(*checkpointPath)
+		if err != nil {
+			log.Fatalf("Failed to read checkpoint: %v", err)
+		}
+		if checkpoint.Source == "" {
+			checkpoint.Source = source
+		}
+		if checkpoint.Source != source {
+			log.Fatalf("Checkpoint %s belongs to %s; use -checkpoint or -restart", *checkpointPath, checkpoint.Source)
+		}
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	var reader importer.Reader
+	switch *format {
+	case "csv":
+		reader, err = importer.NewCSVReader(bufio.NewReader(file))
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "jsonl", "ndjson":
+		reader = importer.NewJSONLinesReader(file)
+	default:
+		log.Fatalf("Unknown format %q, use -format csv or -format jsonl", *format)
+	}
+
+	// The report of a resumed import continues the previous one
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if checkpoint.Records == 0 {
+		flags |= os.O_TRUNC
+	}
+	rejects, err := os.OpenFile(*rejectsPath, flags, 0o644)
+	if err != nil {
+		log.Fatalf("Failed to open rejection report: %v", err)
+	}
+	defer rejects.Close()
+
+	cfg := config.LoadConfig()
+	database.ConnectPostgres(cfg)
+
+	if checkpoint.Records > 0 {
+		log.Printf("Resuming after record %d", checkpoint.Records)
+	}
+	save := func(cp *importer.Checkpoint) error {
+		if err := importer.SaveCheckpoint(*checkpointPath, cp); err != nil {
+			return fmt.Errorf("saving checkpoint: %w", err)
+		}
+		log.Printf("%d records: %d imported, %d skipped, %d rejected", cp.Records, cp.Imported, cp.Skipped, cp.Rejected)
+		return nil
+	}
+	imp := importer.New(repository.NewUserRepository(database.DB), *batchSize, rejects, save)
+	if err := imp.Run(reader, checkpoint); err != nil {
+		log.Fatalf("Import stopped after record %d: %v", checkpoint.Records, err)
+	}
+
+	log.Printf("Done: %d imported, %d skipped, %d rejected (see %s)", checkpoint.Imported, checkpoint.Skipped, checkpoint.Rejected, *rejectsPath)
+}
@@ -0,0 +1,60 @@
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Corpus answers whether a password appeared in a known data breach.
+type Corpus interface {
+	Contains(password string) (bool, error)
+}
+
+// DirCorpus reads a local copy of the Pwned Passwords range files, so no
+// password or hash prefix ever leaves the service. The directory holds one
+// file per 5 hex digit prefix of the SHA-1 hash, named like 5BAA6.txt, with
+// lines of "<remaining 35 hex digits>:<count>".
+type DirCorpus struct {
+	dir string
+}
+
+func NewDirCorpus(dir string) (*DirCorpus, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &DirCorpus{dir}, nil
+}
+
+func (c *DirCorpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
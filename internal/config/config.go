@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -18,6 +20,77 @@ type Config struct {
 	JWTSecret     string
 	RefreshSecret string
 	AppPort       string
+	Issuer        string // Public base URL, used as OIDC issuer
+
+	// Address of the internal listener serving /metrics, kept off the public
+	// port; empty disables it
+	MetricsAddr string
+
+	// Access token signing: HS256 (shared JWT_SECRET), RS256, ES256 or EdDSA
+	JWTAlgorithm      string
+	JWTPrivateKeyPath string
+	JWTKeyID          string
+
+	// Key rings on disk, shared by every instance; rotation is off when the
+	// interval is zero
+	JWTKeysDir             string
+	JWTKeyRotationInterval time.Duration
+	JWTKeyActivationDelay  time.Duration
+
+	// MFA: key encrypting TOTP secrets at rest, and the issuer name shown in
+	// authenticator apps
+	MFAEncryptionKey string
+	MFAIssuer        string
+
+	// How long a device stays trusted after an MFA login that asked to
+	// remember it; zero disables trusted devices
+	TrustedDeviceTTL time.Duration
+
+	// WebAuthn relying party: the domain passkeys are bound to and the
+	// comma separated origins allowed to use them
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins string
+
+	// Outgoing mail, and the page magic links point to. The page receives
+	// email and token query parameters and posts them to the API.
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+	MagicLinkURL string
+
+	// Page password reset links point to, receiving the same email and token
+	// query parameters as magic links
+	PasswordResetURL string
+
+	// Argon2id parameters of new password hashes; memory is in KiB. Stronger
+	// settings apply to existing users at their next login.
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+
+	// Secrets mixed into password hashes before Argon2id, as comma separated
+	// "<version>:<secret>" pairs. New hashes use the highest version; older
+	// ones stay listed until no hash uses them.
+	PasswordPeppers string
+
+	// Password policy. MinClasses counts lower case, upper case, digits and
+	// symbols; History is how many recent passwords, the current one
+	// included, cannot be reused. The breached password check is off without
+	// a directory of Pwned Passwords range files.
+	PasswordMinLength    int
+	PasswordMaxLength    int
+	PasswordMinClasses   int
+	PasswordHistory      int
+	BreachedPasswordsDir string
+
+	// SMS gateway webhook. Without it SMS codes are disabled, unless
+	// SMSLogCodes writes them to the log for local development.
+	SMSWebhookURL   string
+	SMSWebhookToken string
+	SMSLogCodes     bool
 }
 
 func LoadConfig() *Config {
@@ -37,12 +110,102 @@ func LoadConfig() *Config {
 		JWTSecret:     getEnv("JWT_SECRET", "default_secret"),
 		RefreshSecret: getEnv("REFRESH_SECRET", "default_refresh_secret"),
 		AppPort:       getEnv("APP_PORT", "8888"),
+		Issuer:        getEnv("ISSUER_URL", "http://localhost:8888"),
+
+		MetricsAddr: getEnv("METRICS_ADDR", "127.0.0.1:9090"),
+
+		JWTAlgorithm:      getEnv("JWT_ALGORITHM", "HS256"),
+		JWTPrivateKeyPath: getEnv("JWT_PRIVATE_KEY_PATH", ""),
+		JWTKeyID:          getEnv("JWT_KEY_ID", ""),
+
+		JWTKeysDir:             getEnv("JWT_KEYS_DIR", ""),
+		JWTKeyRotationInterval: getEnvDuration("JWT_KEY_ROTATION_INTERVAL", 0),
+		JWTKeyActivationDelay:  getEnvDuration("JWT_KEY_ACTIVATION_DELAY", 10*time.Minute),
+
+		MFAEncryptionKey: getEnv("MFA_ENCRYPTION_KEY", "default_mfa_encryption_key"),
+		MFAIssuer:        getEnv("MFA_ISSUER", "Auth Service"),
+
+		TrustedDeviceTTL: getEnvDuration("TRUSTED_DEVICE_TTL", 30*24*time.Hour),
+
+		WebAuthnRPID:    getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:  getEnv("WEBAUTHN_RP_NAME", "Auth Service"),
+		WebAuthnOrigins: getEnv("WEBAUTHN_ORIGINS", "http://localhost:8888"),
+
+		SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:     getEnv("SMTP_PORT", "1025"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "no-reply@localhost"),
+		MagicLinkURL: getEnv("MAGIC_LINK_URL", "http://localhost:3000/login/email"),
+
+		PasswordResetURL: getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+
+		Argon2Time:    getEnvInt("ARGON2_TIME", 1),
+		Argon2Memory:  getEnvInt("ARGON2_MEMORY", 64*1024),
+		Argon2Threads: getEnvInt("ARGON2_THREADS", 4),
+
+		PasswordPeppers: getEnv("PASSWORD_PEPPERS", ""),
+
+		PasswordMinLength:    getEnvInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordMaxLength:    getEnvInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordMinClasses:   getEnvInt("PASSWORD_MIN_CLASSES", 2),
+		PasswordHistory:      getEnvInt("PASSWORD_HISTORY", 5),
+		BreachedPasswordsDir: getEnv("BREACHED_PASSWORDS_DIR", ""),
+
+		SMSWebhookURL:   getEnv("SMS_WEBHOOK_URL", ""),
+		SMSWebhookToken: getEnv("SMS_WEBHOOK_TOKEN", ""),
+		SMSLogCodes:     getEnvBool("SMS_LOG_CODES", false),
 	}
 }
 
+// SMSEnabled reports whether SMS codes can be sent, through the gateway or
+// to the log.
+func (c *Config) SMSEnabled() bool {
+	return c.SMSWebhookURL != "" || c.SMSLogCodes
+}
+
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %v, using %s", key, err, fallback)
+		return fallback
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid number for %s: %v, using %d", key, err, fallback)
+		return fallback
+	}
+	return n
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s: %v, using %t", key, err, fallback)
+		return fallback
+	}
+	return b
+}
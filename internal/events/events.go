@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RefreshTokenReused = "refresh_token_reused"
+	PasswordReset      = "password_reset"
+	PasswordChanged    = "password_changed"
+)
+
+// Channel is the Redis Pub/Sub channel security events are published on.
+const Channel = "auth:security-events"
+
+type Event struct {
+	Type   string                 `json:"type"`
+	UserID uint                   `json:"user_id,omitempty"`
+	Time   time.Time              `json:"time"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+type Publisher interface {
+	Publish(event Event)
+}
+
+func New(eventType string, userID uint, data map[string]interface{}) Event {
+	return Event{Type: eventType, UserID: userID, Time: time.Now().UTC(), Data: data}
+}
+
+// RedisPublisher logs every event and publishes it as JSON on Channel so
+// other services (notifications, SIEM) can subscribe.
+type RedisPublisher struct {
+	redis *redis.Client
+}
+
+func NewRedisPublisher(redis *redis.Client) *RedisPublisher {
+	return &RedisPublisher{redis}
+}
+
+func (p *RedisPublisher) Publish(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode security event %s: %v", event.Type, err)
+		return
+	}
+
+	log.Printf("Security event: %s", payload)
+	if err := p.redis.Publish(context.Background(), Channel, payload).Err(); err != nil {
+		log.Printf("Failed to publish security event %s: %v", event.Type, err)
+	}
+}
+
+// MemoryPublisher records events in memory, for tests.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	Events []Event
+}
+
+func (p *MemoryPublisher) Publish(event Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Events = append(p.Events, event)
+}
+
+func (p *MemoryPublisher) Types() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	types := make([]string, len(p.Events))
+	for i, e := range p.Events {
+		types[i] = e.Type
+	}
+	return types
+}
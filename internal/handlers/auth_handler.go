@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
 	"auth-service/internal/services"
 
@@ -19,11 +21,19 @@ func NewAuthHandler(service *services.AuthService) *AuthHandler {
 type RegisterRequest struct {
 	Name     string `json:"name" binding:"required"`
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
+	Email      string `json:"email" binding:"required,email"`
+	Password   string `json:"password" binding:"required"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+
+	// DeviceToken skips the second factor on a trusted device
+	DeviceToken string `json:"device_token"`
+}
+
+type ReauthRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
@@ -31,6 +41,14 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
+func clientInfo(c *gin.Context, deviceName string) services.ClientInfo {
+	return services.ClientInfo{
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		DeviceName: deviceName,
+	}
+}
+
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,6 +57,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	err := h.service.Register(req.Name, req.Email, req.Password)
+	if writePasswordPolicyError(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -54,7 +75,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := h.service.Login(req.Email, req.Password)
+	client := clientInfo(c, req.DeviceName)
+	client.DeviceToken = req.DeviceToken
+	token, err := h.service.Login(req.Email, req.Password, client)
+	if writeMFARequired(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -63,6 +89,23 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, token)
 }
 
+// writeMFARequired answers a login that still needs a second factor, and
+// reports whether it did.
+func writeMFARequired(c *gin.Context, err error) bool {
+	var mfaErr *services.MFARequiredError
+	if !errors.As(err, &mfaErr) {
+		return false
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mfa_required": true,
+		"mfa_token":    mfaErr.Token,
+		"methods":      mfaErr.Methods,
+		"expires_in":   mfaErr.ExpiresIn,
+	})
+	return true
+}
+
 func (h *AuthHandler) Refresh(c *gin.Context) {
 	var req RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -70,7 +113,7 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	token, err := h.service.Refresh(req.RefreshToken)
+	token, err := h.service.Refresh(req.RefreshToken, clientInfo(c, ""))
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
@@ -78,3 +121,100 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 
 	c.JSON(http.StatusOK, token)
 }
+
+// Reauthenticate upgrades the current session after the user proved their
+// identity again. It answers like Login, with new tokens for the same session.
+func (h *AuthHandler) Reauthenticate(c *gin.Context) {
+	var req ReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.service.Reauthenticate(c.GetUint("user_id"), c.GetString("family_id"), req.Password, clientInfo(c, ""))
+	if writeMFARequired(c, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCredentials) || errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if err := h.service.Logout(c.GetString("access_uuid"), c.GetString("family_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	if err := h.service.LogoutAll(c.GetUint("user_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all devices"})
+}
+
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.service.ListSessions(c.GetUint("user_id"), c.GetString("family_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	err := h.service.RevokeSession(c.GetUint("user_id"), c.Param("id"))
+	if errors.Is(err, services.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+func (h *AuthHandler) ListTrustedDevices(c *gin.Context) {
+	devices, err := h.service.ListTrustedDevices(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+func (h *AuthHandler) RevokeTrustedDevice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrTrustedDeviceNotFound.Error()})
+		return
+	}
+
+	err = h.service.RevokeTrustedDevice(c.GetUint("user_id"), uint(id))
+	if errors.Is(err, services.ErrTrustedDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trusted device revoked"})
+}
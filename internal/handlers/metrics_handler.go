@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"auth-service/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics serves the legacy password migration progress in the Prometheus
+// text format.
+func (h *AuthHandler) Metrics(c *gin.Context) {
+	remaining, err := h.service.LegacyPasswordCounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to collect metrics"})
+		return
+	}
+
+	var buf bytes.Buffer
+	metrics.WriteFamily(&buf, "auth_legacy_password_users", "gauge",
+		"Users whose password is still stored with a legacy hash.", "algorithm", remaining)
+	metrics.WriteFamily(&buf, "auth_legacy_password_migrations_total", "counter",
+		"Legacy password hashes replaced by Argon2id at login.", "algorithm", metrics.LegacyPasswordMigrations.Snapshot())
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
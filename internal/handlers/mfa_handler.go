@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	service *services.MFAService
+}
+
+func NewMFAHandler(service *services.MFAService) *MFAHandler {
+	return &MFAHandler{service}
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Method   string `json:"method" binding:"required,oneof=totp recovery_code sms webauthn"`
+	Code     string `json:"code"`
+
+	// Credential is the authenticator's assertion for the webauthn method
+	Credential json.RawMessage `json:"credential"`
+
+	RememberDevice bool `json:"remember_device"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type SMSEnrollRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type SMSLoginCodeRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// writeMFAError maps MFA errors to responses; anything unknown is a 500.
+func writeMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrMFAChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnrolled), errors.Is(err, services.ErrInvalidPhone):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSMSThrottled), errors.Is(err, services.ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSMSUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// Verify completes a login that answered with mfa_required.
+func (h *MFAHandler) Verify(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := req.Code
+	if req.Method == services.MFAMethodWebAuthn {
+		code = string(req.Credential)
+	}
+
+	token, err := h.service.Verify(req.MFAToken, req.Method, code, req.RememberDevice)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *MFAHandler) EnrollTOTP(c *gin.Context) {
+	enrollment, err := h.service.EnrollTOTP(c.GetUint("user_id"))
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *MFAHandler) ConfirmTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.service.ConfirmTOTP(c.GetUint("user_id"), req.Code)
+	if err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.DisableTOTP(c.GetUint("user_id"), req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// SendSMSLoginCode texts the code for a login that answered with
+// mfa_required and offers the sms method.
+func (h *MFAHandler) SendSMSLoginCode(c *gin.Context) {
+	var req SMSLoginCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.SendSMSLoginCode(req.MFAToken); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Code sent"})
+}
+
+func (h *MFAHandler) EnrollSMS(c *gin.Context) {
+	var req SMSEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.EnrollSMS(c.GetUint("user_id"), req.Phone); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Code sent"})
+}
+
+func (h *MFAHandler) ConfirmSMS(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.ConfirmSMS(c.GetUint("user_id"), req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Phone number verified"})
+}
+
+// SendSMSAccountCode texts a code to the verified number, for DisableSMS.
+func (h *MFAHandler) SendSMSAccountCode(c *gin.Context) {
+	if err := h.service.SendSMSAccountCode(c.GetUint("user_id")); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Code sent"})
+}
+
+func (h *MFAHandler) DisableSMS(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.DisableSMS(c.GetUint("user_id"), req.Code); err != nil {
+		writeMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SMS verification disabled"})
+}
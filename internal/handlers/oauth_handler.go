@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthHandler struct {
+	service *services.OAuthService
+}
+
+func NewOAuthHandler(service *services.OAuthService) *OAuthHandler {
+	return &OAuthHandler{service}
+}
+
+type IntrospectRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+}
+
+type RevokeRequest struct {
+	Token         string `form:"token" binding:"required"`
+	TokenTypeHint string `form:"token_type_hint"`
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"`
+}
+
+type TokenRequest struct {
+	GrantType    string `form:"grant_type" binding:"required"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Scope        string `form:"scope"`
+
+	SubjectToken       string `form:"subject_token"`
+	SubjectTokenType   string `form:"subject_token_type"`
+	RequestedTokenType string `form:"requested_token_type"`
+	Audience           string `form:"audience"`
+}
+
+type DeviceAuthorizationRequest struct {
+	Scope string `form:"scope"`
+}
+
+type DeviceVerifyRequest struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Approve  *bool  `json:"approve" binding:"required"`
+}
+
+// oauthError writes an RFC 6749 error response.
+func oauthError(c *gin.Context, status int, code, description string) {
+	body := gin.H{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	c.AbortWithStatusJSON(status, body)
+}
+
+// writeOAuthError maps service errors to RFC 6749 responses.
+func writeOAuthError(c *gin.Context, err error) {
+	var oerr *services.OAuthError
+	if !errors.As(err, &oerr) {
+		oauthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oerr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	oauthError(c, status, oerr.Code, oerr.Description)
+}
+
+func clientCredentials(c *gin.Context) (string, string) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	return clientID, secret
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post. With
+// allowPublic, public clients may identify themselves by client_id only.
+func (h *OAuthHandler) authenticateClient(c *gin.Context, allowPublic bool) (*models.Client, bool) {
+	clientID, secret := clientCredentials(c)
+
+	var client *models.Client
+	var err error
+	if allowPublic {
+		client, err = h.service.IdentifyClient(clientID, secret)
+	} else {
+		client, err = h.service.AuthenticateClient(clientID, secret)
+	}
+	if err != nil {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
+
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	if _, ok := h.authenticateClient(c, false); !ok {
+		return
+	}
+
+	var req IntrospectRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, h.service.Introspect(req.Token, req.TokenTypeHint))
+}
+
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	client, ok := h.authenticateClient(c, true)
+	if !ok {
+		return
+	}
+
+	var req RevokeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	err := h.service.Revoke(client, req.Token, req.TokenTypeHint)
+	if errors.Is(err, services.ErrUnauthorizedClient) {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", "Token was not issued to this client")
+		return
+	}
+	if err != nil {
+		oauthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	// Unknown and already revoked tokens are also answered with 200
+	c.Status(http.StatusOK)
+}
+
+// Authorize runs behind AuthMiddleware: the user is already logged in with a
+// first-party token and approves the client by calling this endpoint.
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	redirectURI, code, err := h.service.Authorize(c.GetUint("user_id"), c.GetString("family_id"), services.AuthorizeRequest{
+		ResponseType:        req.ResponseType,
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+	})
+	if redirectURI == "" {
+		writeOAuthError(c, err)
+		return
+	}
+
+	target, parseErr := url.Parse(redirectURI)
+	if parseErr != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Invalid redirect_uri")
+		return
+	}
+	query := target.Query()
+	var oerr *services.OAuthError
+	switch {
+	case errors.As(err, &oerr):
+		query.Set("error", oerr.Code)
+		if oerr.Description != "" {
+			query.Set("error_description", oerr.Description)
+		}
+	case err != nil:
+		query.Set("error", "server_error")
+	default:
+		query.Set("code", code)
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, target.String())
+}
+
+func (h *OAuthHandler) Token(c *gin.Context) {
+	client, ok := h.authenticateClient(c, true)
+	if !ok {
+		return
+	}
+
+	var req TokenRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	token, err := h.service.Token(client, services.TokenRequest{
+		GrantType:    req.GrantType,
+		Code:         req.Code,
+		RedirectURI:  req.RedirectURI,
+		CodeVerifier: req.CodeVerifier,
+		RefreshToken: req.RefreshToken,
+		DeviceCode:   req.DeviceCode,
+		Scope:        req.Scope,
+
+		SubjectToken:       req.SubjectToken,
+		SubjectTokenType:   req.SubjectTokenType,
+		RequestedTokenType: req.RequestedTokenType,
+		Audience:           req.Audience,
+	}, clientInfo(c, ""))
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, token)
+}
+
+func (h *OAuthHandler) DeviceAuthorization(c *gin.Context) {
+	client, ok := h.authenticateClient(c, true)
+	if !ok {
+		return
+	}
+
+	var req DeviceAuthorizationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	resp, err := h.service.DeviceAuthorization(client, req.Scope)
+	if err != nil {
+		writeOAuthError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeviceInfo shows the logged-in user which client a user code belongs to
+// before they approve it.
+func (h *OAuthHandler) DeviceInfo(c *gin.Context) {
+	auth, client, err := h.service.DeviceClient(c.Query("user_code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"client_id":   client.ClientID,
+		"client_name": client.Name,
+		"scope":       auth.Scope,
+		"expires_at":  auth.ExpiresAt,
+	})
+}
+
+// DeviceVerify approves or denies a user code on behalf of the logged-in
+// user.
+func (h *OAuthHandler) DeviceVerify(c *gin.Context) {
+	var req DeviceVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.ApproveDevice(c.GetUint("user_id"), c.GetString("family_id"), req.UserCode, *req.Approve)
+	if errors.Is(err, services.ErrInvalidUserCode) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if *req.Approve {
+		c.JSON(http.StatusOK, gin.H{"message": "Device approved"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Device denied"})
+}
+
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	_, isClientToken := c.Get("client_id")
+	claims, err := h.service.UserInfo(c.GetUint("user_id"), c.GetString("scope"), !isClientToken)
+	if errors.Is(err, services.ErrInsufficientScope) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		oauthError(c, http.StatusForbidden, "insufficient_scope", "")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, claims)
+}
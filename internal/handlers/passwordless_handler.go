@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordlessHandler struct {
+	service *services.PasswordlessService
+}
+
+func NewPasswordlessHandler(service *services.PasswordlessService) *PasswordlessHandler {
+	return &PasswordlessHandler{service}
+}
+
+type EmailLoginRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+type EmailCodeRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Code       string `json:"code" binding:"required"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+type MagicLinkRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Token      string `json:"token" binding:"required"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+// writeOTPResult answers a passwordless verification like a password login.
+func writeOTPResult(c *gin.Context, token *utils.TokenDetails, err error) {
+	if writeMFARequired(c, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidOTP) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrOTPLocked) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// StartEmail sends a sign-in code and magic link. The answer is the same
+// whether or not the address has an account.
+func (h *PasswordlessHandler) StartEmail(c *gin.Context) {
+	var req EmailLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.StartEmailLogin(req.Email, clientInfo(c, req.DeviceName))
+	if errors.Is(err, services.ErrOTPThrottled) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send sign-in email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address has an account, a sign-in email was sent"})
+}
+
+func (h *PasswordlessHandler) VerifyEmailCode(c *gin.Context) {
+	var req EmailCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.service.VerifyEmailCode(req.Email, req.Code, clientInfo(c, req.DeviceName))
+	writeOTPResult(c, token, err)
+}
+
+func (h *PasswordlessHandler) VerifyMagicLink(c *gin.Context) {
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.service.VerifyMagicLink(req.Email, req.Token, clientInfo(c, req.DeviceName))
+	writeOTPResult(c, token, err)
+}
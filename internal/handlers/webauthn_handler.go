@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"auth-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnHandler struct {
+	service *services.WebAuthnService
+}
+
+func NewWebAuthnHandler(service *services.WebAuthnService) *WebAuthnHandler {
+	return &WebAuthnHandler{service}
+}
+
+type PasskeyRegisterRequest struct {
+	Name string `json:"name"`
+}
+
+type WebAuthnFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type WebAuthnMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// writeWebAuthnError maps WebAuthn errors to responses; anything unknown is
+// a 500.
+func writeWebAuthnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrWebAuthnSession), errors.Is(err, services.ErrWebAuthnFailed):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCredentialNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		writeMFAError(c, err)
+	}
+}
+
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	var req PasskeyRegisterRequest
+	// The body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	sessionID, options, err := h.service.BeginRegistration(c.GetUint("user_id"), req.Name)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
+}
+
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	var req WebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cred, err := h.service.FinishRegistration(c.GetUint("user_id"), req.SessionID, req.Credential)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, cred)
+}
+
+func (h *WebAuthnHandler) ListCredentials(c *gin.Context) {
+	creds, err := h.service.ListCredentials(c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, creds)
+}
+
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrCredentialNotFound.Error()})
+		return
+	}
+
+	if err := h.service.DeleteCredential(c.GetUint("user_id"), uint(id)); err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey removed"})
+}
+
+// BeginLogin starts a passwordless login with a passkey.
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	sessionID, options, err := h.service.BeginLogin()
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
+}
+
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var req WebAuthnFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.service.FinishLogin(req.SessionID, req.Credential, clientInfo(c, ""))
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// BeginMFA returns the assertion options for a login that answered with
+// mfa_required. The result is sent to /mfa/verify with method webauthn.
+func (h *WebAuthnHandler) BeginMFA(c *gin.Context) {
+	var req WebAuthnMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, err := h.service.BeginMFA(req.MFAToken)
+	if err != nil {
+		writeWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
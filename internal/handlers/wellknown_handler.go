@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"auth-service/internal/config"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WellKnownHandler struct {
+	keys *utils.KeySet
+	cfg  *config.Config
+}
+
+func NewWellKnownHandler(keys *utils.KeySet, cfg *config.Config) *WellKnownHandler {
+	return &WellKnownHandler{keys, cfg}
+}
+
+func (h *WellKnownHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
+
+// OpenIDConfiguration serves the OIDC discovery document.
+func (h *WellKnownHandler) OpenIDConfiguration(c *gin.Context) {
+	issuer := strings.TrimRight(h.cfg.Issuer, "/")
+
+	// ID tokens need a key the relying parties can verify through the JWKS
+	scopes := []string{"email", "profile"}
+	idTokenAlgs := []string{}
+	if key := h.keys.Active(); key.Asymmetric() {
+		scopes = append([]string{"openid"}, scopes...)
+		idTokenAlgs = []string{key.Method.Alg()}
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"device_authorization_endpoint":         issuer + "/oauth/device_authorization",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", services.DeviceCodeGrantType, services.TokenExchangeGrantType},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": idTokenAlgs,
+		"scopes_supported":                      scopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "acr", "amr", "nonce", "email", "name", "updated_at"},
+		"acr_values_supported":                  []string{utils.ACRBasic, utils.ACRMFA},
+	})
+}
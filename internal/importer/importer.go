@@ -0,0 +1,229 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"auth-service/internal/legacy"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+)
+
+// Postgres accepts at most 65535 parameters per statement, about 7000 users.
+const MaxBatchSize = 5000
+
+// Checkpoint records how far an import went. Records counts every record
+// read from the source, so a resumed import skips exactly those.
+type Checkpoint struct {
+	Source    string    `json:"source"`
+	Records   int64     `json:"records"`
+	Imported  int64     `json:"imported"`
+	Skipped   int64     `json:"skipped"`
+	Rejected  int64     `json:"rejected"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// LoadCheckpoint reads a checkpoint. A missing file is an empty checkpoint.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Checkpoint{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// SaveCheckpoint replaces the checkpoint file atomically, so a crash leaves
+// either the previous checkpoint or the new one.
+func SaveCheckpoint(path string, cp *Checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Rejection explains why a record was not imported. Record is its position
+// in the source, starting at 1.
+type Rejection struct {
+	Record int64  `json:"record"`
+	Email  string `json:"email,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Importer upserts records in batches. Rejected records are written to the
+// report as JSON lines, and the checkpoint is saved after every batch.
+type Importer struct {
+	users     repository.UserRepository
+	batchSize int
+	report    *json.Encoder
+	save      func(*Checkpoint) error
+	now       func() time.Time
+}
+
+func New(users repository.UserRepository, batchSize int, report io.Writer, save func(*Checkpoint) error) *Importer {
+	batchSize = min(max(batchSize, 1), MaxBatchSize)
+	return &Importer{
+		users:     users,
+		batchSize: batchSize,
+		report:    json.NewEncoder(report),
+		save:      save,
+		now:       time.Now,
+	}
+}
+
+// Run imports the records of r after the ones cp says are done, and updates
+// cp as it goes. Importing the same records again is harmless: users are
+// matched by email, and those who already set a password are skipped.
+func (im *Importer) Run(r Reader, cp *Checkpoint) error {
+	for i := int64(0); i < cp.Records; i++ {
+		if _, err := r.Next(); err != nil && !isMalformed(err) {
+			if err == io.EOF {
+				return errors.New("source has fewer records than the checkpoint")
+			}
+			return err
+		}
+	}
+
+	var (
+		batch      []models.User
+		positions  []int64
+		rejections []Rejection
+		seen       = map[string]int{}
+		position   = cp.Records
+	)
+	flush := func() error {
+		written, err := im.users.UpsertImportedUsers(batch)
+		if err != nil {
+			return err
+		}
+		for _, rejection := range rejections {
+			if err := im.report.Encode(rejection); err != nil {
+				return err
+			}
+		}
+
+		cp.Records = position
+		cp.Imported += written
+		cp.Skipped += int64(len(batch)) - written
+		cp.Rejected += int64(len(rejections))
+		cp.UpdatedAt = im.now()
+		batch, positions, rejections, seen = nil, nil, nil, map[string]int{}
+		return im.save(cp)
+	}
+
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		position++
+		if err != nil {
+			if !isMalformed(err) {
+				return err
+			}
+			rejections = append(rejections, Rejection{Record: position, Reason: err.Error()})
+		} else if user, err := im.user(record); err != nil {
+			rejections = append(rejections, Rejection{Record: position, Email: record.Email, Reason: err.Error()})
+		} else if i, ok := seen[user.Email]; ok {
+			// One statement cannot upsert the same row twice. The last
+			// record wins, as it does across batches.
+			rejections = append(rejections, Rejection{Record: positions[i], Email: user.Email, Reason: fmt.Sprintf("duplicate email, replaced by record %d", position)})
+			batch[i], positions[i] = *user, position
+		} else {
+			seen[user.Email] = len(batch)
+			batch = append(batch, *user)
+			positions = append(positions, position)
+		}
+
+		if len(batch)+len(rejections) >= im.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if position > cp.Records {
+		return flush()
+	}
+	return nil
+}
+
+func isMalformed(err error) bool {
+	var malformed *MalformedError
+	return errors.As(err, &malformed)
+}
+
+// user validates a record and turns it into a user with a legacy hash.
+func (im *Importer) user(record Record) (*models.User, error) {
+	email, err := NormalizeEmail(record.Email)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := legacy.Normalize(record.HashAlgorithm, record.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := im.now()
+	createdAt := now
+	if value := strings.TrimSpace(record.CreatedAt); value != "" {
+		createdAt, err = parseTime(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.User{
+		Email:              email,
+		Name:               strings.TrimSpace(record.Name),
+		LegacyPasswordHash: hash,
+		CreatedAt:          createdAt,
+		UpdatedAt:          now,
+	}, nil
+}
+
+var errInvalidEmail = errors.New("invalid email address")
+
+// NormalizeEmail normalizes an email address like models.NormalizeEmail, and
+// refuses anything but a bare address such as display names or comments.
+func NormalizeEmail(email string) (string, error) {
+	email = models.NormalizeEmail(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", errInvalidEmail
+	}
+	if domain := email[strings.LastIndex(email, "@")+1:]; !strings.Contains(domain, ".") {
+		return "", errInvalidEmail
+	}
+	return email, nil
+}
+
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05", "2006-01-02"}
+
+// parseTime accepts RFC 3339 and the common SQL export formats, in UTC when
+// they have no offset.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, errors.New("invalid created_at: " + value)
+}
@@ -0,0 +1,153 @@
+package importer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"auth-service/internal/importer"
+	"auth-service/internal/models"
+	"auth-service/internal/repository/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const usersCSV = `email,name,password_hash,hash_algorithm,created_at
+ John@Example.COM ,John,482c811da5d5b4bc6d497ffa98491e38,md5,2015-03-02 10:00:00
+jane@example.com,Jane,$2y$10$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui,,2016-01-01T00:00:00Z
+not-an-email,Nobody,482c811da5d5b4bc6d497ffa98491e38,md5,
+bob@example.com,Bob,abc,crypt,
+only,two
+ann@example.com,Ann,pbkdf2_sha256$1000$salt$a2V5,pbkdf2_sha256,
+john@example.com,John Smith,cbfdac6008f9cab4083784cbd1874f76618d2a97,,
+`
+
+func rejections(t *testing.T, report *bytes.Buffer) []importer.Rejection {
+	var rejected []importer.Rejection
+	for _, line := range strings.Split(strings.TrimSpace(report.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r importer.Rejection
+		assert.NoError(t, json.Unmarshal([]byte(line), &r))
+		rejected = append(rejected, r)
+	}
+	return rejected
+}
+
+func TestImport_CSV(t *testing.T) {
+	userRepo := new(mocks.MockUserRepository)
+	var upserted [][]models.User
+	userRepo.On("UpsertImportedUsers", mock.Anything).Run(func(args mock.Arguments) {
+		upserted = append(upserted, args.Get(0).([]models.User))
+	}).Return(int64(3), nil)
+
+	reader, err := importer.NewCSVReader(strings.NewReader(usersCSV))
+	assert.NoError(t, err)
+	var report bytes.Buffer
+	var saved []importer.Checkpoint
+	imp := importer.New(userRepo, 100, &report, func(cp *importer.Checkpoint) error {
+		saved = append(saved, *cp)
+		return nil
+	})
+
+	cp := &importer.Checkpoint{}
+	assert.NoError(t, imp.Run(reader, cp))
+
+	assert.Len(t, upserted, 1)
+	users := upserted[0]
+	assert.Len(t, users, 3)
+	// The later record for the same address replaces the earlier one
+	assert.Equal(t, "john@example.com", users[0].Email)
+	assert.Equal(t, "John Smith", users[0].Name)
+	assert.Equal(t, "sha1:cbfdac6008f9cab4083784cbd1874f76618d2a97", users[0].LegacyPasswordHash)
+	assert.Equal(t, "bcrypt:$2y$10$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui", users[1].LegacyPasswordHash)
+	assert.Equal(t, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), users[1].CreatedAt)
+	assert.Equal(t, "pbkdf2_sha256:1000$salt$a2V5", users[2].LegacyPasswordHash)
+	assert.Empty(t, users[2].Password)
+
+	rejected := rejections(t, &report)
+	assert.Len(t, rejected, 4)
+	reasons := map[int64]string{}
+	for _, r := range rejected {
+		reasons[r.Record] = r.Reason
+	}
+	assert.Contains(t, reasons[1], "replaced by record 7")
+	assert.Contains(t, reasons[3], "invalid email")
+	assert.Contains(t, reasons[4], "unknown legacy hash algorithm")
+	assert.Contains(t, reasons[5], "malformed record")
+
+	assert.Equal(t, importer.Checkpoint{Records: 7, Imported: 3, Rejected: 4}, importer.Checkpoint{
+		Records: cp.Records, Imported: cp.Imported, Skipped: cp.Skipped, Rejected: cp.Rejected,
+	})
+	assert.Len(t, saved, 1)
+}
+
+func TestImport_ResumesFromCheckpoint(t *testing.T) {
+	input := `{"email":"a@example.com","password_hash":"482c811da5d5b4bc6d497ffa98491e38"}
+{"email":"b@example.com","password_hash":"482c811da5d5b4bc6d497ffa98491e38"}
+
+{"email":"c@example.com","password_hash":"482c811da5d5b4bc6d497ffa98491e38"}
+{not json}
+{"email":"d@example.com","password_hash":"482c811da5d5b4bc6d497ffa98491e38"}
+`
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.checkpoint.json")
+	save := func(cp *importer.Checkpoint) error { return importer.SaveCheckpoint(path, cp) }
+
+	// The first run stops when the database fails on the second batch
+	userRepo := new(mocks.MockUserRepository)
+	userRepo.On("UpsertImportedUsers", mock.Anything).Return(int64(2), nil).Once()
+	userRepo.On("UpsertImportedUsers", mock.Anything).Return(int64(0), assert.AnError).Once()
+	var report bytes.Buffer
+	cp, err := importer.LoadCheckpoint(path)
+	assert.NoError(t, err)
+	err = importer.New(userRepo, 2, &report, save).Run(importer.NewJSONLinesReader(strings.NewReader(input)), cp)
+	assert.ErrorIs(t, err, assert.AnError)
+
+	cp, err = importer.LoadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), cp.Records)
+
+	// The second run starts with c, and one user already set a password
+	userRepo = new(mocks.MockUserRepository)
+	var emails []string
+	collect := func(args mock.Arguments) {
+		for _, u := range args.Get(0).([]models.User) {
+			emails = append(emails, u.Email)
+		}
+	}
+	userRepo.On("UpsertImportedUsers", mock.Anything).Run(collect).Return(int64(1), nil).Once()
+	userRepo.On("UpsertImportedUsers", mock.Anything).Run(collect).Return(int64(0), nil).Once()
+	err = importer.New(userRepo, 2, &report, save).Run(importer.NewJSONLinesReader(strings.NewReader(input)), cp)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c@example.com", "d@example.com"}, emails)
+
+	cp, err = importer.LoadCheckpoint(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), cp.Records)
+	assert.Equal(t, int64(3), cp.Imported)
+	assert.Equal(t, int64(1), cp.Skipped)
+	assert.Equal(t, int64(1), cp.Rejected)
+
+	// Nothing is left to do on a third run
+	userRepo = new(mocks.MockUserRepository)
+	err = importer.New(userRepo, 2, &report, save).Run(importer.NewJSONLinesReader(strings.NewReader(input)), cp)
+	assert.NoError(t, err)
+	userRepo.AssertNotCalled(t, "UpsertImportedUsers", mock.Anything)
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := importer.NormalizeEmail("  Jane.Doe+news@Example.ORG ")
+	assert.NoError(t, err)
+	assert.Equal(t, "jane.doe+news@example.org", email)
+
+	for _, invalid := range []string{"", "jane", "jane@localhost", "Jane <jane@example.com>", "jane@example.com (work)", "a@b@example.com"} {
+		_, err := importer.NormalizeEmail(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
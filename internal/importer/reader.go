@@ -0,0 +1,122 @@
+// Package importer loads users exported from the previous system, keeping
+// their legacy password hashes for migration at their first login.
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Record is one user as exported by the previous system.
+type Record struct {
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	PasswordHash  string `json:"password_hash"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// Column names of the CSV header. Only email and password_hash are required.
+var csvColumns = []string{"email", "name", "password_hash", "hash_algorithm", "created_at"}
+
+// MalformedError reports a record that could not be parsed. Reading can go
+// on with the next record.
+type MalformedError struct {
+	Err error
+}
+
+func (e *MalformedError) Error() string { return "malformed record: " + e.Err.Error() }
+
+func (e *MalformedError) Unwrap() error { return e.Err }
+
+// Reader streams records. Next returns io.EOF after the last one.
+type Reader interface {
+	Next() (Record, error)
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+// NewCSVReader reads comma separated records under a header naming the
+// columns, in any order.
+func NewCSVReader(r io.Reader) (Reader, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	for _, required := range []string{"email", "password_hash"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header has no %q column, expected %s", required, strings.Join(csvColumns, ","))
+		}
+	}
+	return &csvReader{r: cr, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (Record, error) {
+	fields, err := c.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Record{}, &MalformedError{Err: err}
+		}
+		return Record{}, err
+	}
+
+	field := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	return Record{
+		Email:         field("email"),
+		Name:          field("name"),
+		PasswordHash:  field("password_hash"),
+		HashAlgorithm: field("hash_algorithm"),
+		CreatedAt:     field("created_at"),
+	}, nil
+}
+
+type jsonLinesReader struct {
+	s *bufio.Scanner
+}
+
+// NewJSONLinesReader reads one JSON object per line. Blank lines are skipped.
+func NewJSONLinesReader(r io.Reader) Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &jsonLinesReader{s: s}
+}
+
+func (j *jsonLinesReader) Next() (Record, error) {
+	for j.s.Scan() {
+		line := strings.TrimSpace(j.s.Text())
+		if line == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return Record{}, &MalformedError{Err: err}
+		}
+		return record, nil
+	}
+	if err := j.s.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
@@ -0,0 +1,196 @@
+// Package legacy verifies password hashes imported from the previous system,
+// so users can log in once and be moved to Argon2id.
+//
+// A stored legacy hash names its algorithm with a prefix, as in
+// "md5:5f4dcc3b5aa765d61d8327deb882cf99". Hashes without a prefix are
+// recognised by their format: bcrypt ($2a$, $2b$, $2y$), Django PBKDF2
+// (pbkdf2_sha256$) and unsalted hex MD5 or SHA-1 by their length.
+package legacy
+
+import (
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MD5          = "md5"
+	SHA1         = "sha1"
+	Bcrypt       = "bcrypt"
+	PBKDF2SHA256 = "pbkdf2_sha256"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown legacy hash algorithm")
+	ErrInvalidHash      = errors.New("invalid legacy hash")
+)
+
+// Verifier checks a password against a hash, without the algorithm prefix.
+type Verifier interface {
+	Verify(password, hash string) (bool, error)
+}
+
+// VerifierFunc adapts a function to a Verifier.
+type VerifierFunc func(password, hash string) (bool, error)
+
+func (f VerifierFunc) Verify(password, hash string) (bool, error) {
+	return f(password, hash)
+}
+
+var (
+	mu        sync.RWMutex
+	verifiers = map[string]Verifier{}
+)
+
+// Register makes a verifier available for hashes prefixed with algorithm.
+// Registering an algorithm again replaces its verifier.
+func Register(algorithm string, v Verifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	verifiers[algorithm] = v
+}
+
+// Algorithms lists the registered algorithms.
+func Algorithms() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(verifiers))
+	for name := range verifiers {
+		names = append(names, name)
+	}
+	return names
+}
+
+func init() {
+	Register(MD5, digestVerifier(md5.New))
+	Register(SHA1, digestVerifier(sha1.New))
+	Register(Bcrypt, VerifierFunc(verifyBcrypt))
+	Register(PBKDF2SHA256, VerifierFunc(verifyPBKDF2SHA256))
+}
+
+var (
+	prefixPattern = regexp.MustCompile(`^([a-z0-9_-]+):(.+)$`)
+	hexPattern    = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// Split returns the algorithm of a stored hash and the hash without prefix.
+func Split(stored string) (algorithm, hash string) {
+	if m := prefixPattern.FindStringSubmatch(stored); m != nil {
+		return m[1], m[2]
+	}
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		return Bcrypt, stored
+	case strings.HasPrefix(stored, PBKDF2SHA256+"$"):
+		return PBKDF2SHA256, strings.TrimPrefix(stored, PBKDF2SHA256+"$")
+	case hexPattern.MatchString(stored) && len(stored) == 2*md5.Size:
+		return MD5, stored
+	case hexPattern.MatchString(stored) && len(stored) == 2*sha1.Size:
+		return SHA1, stored
+	}
+	return "", stored
+}
+
+// Normalize returns hash in the stored form "<algorithm>:<hash>". The
+// algorithm is detected from the hash when empty, and a hash that already
+// names the same algorithm is not prefixed twice.
+func Normalize(algorithm, hash string) (string, error) {
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return "", ErrInvalidHash
+	}
+
+	detected, stripped := Split(hash)
+	if algorithm == "" {
+		algorithm = detected
+	}
+	if algorithm == detected {
+		hash = stripped
+	}
+
+	mu.RLock()
+	_, ok := verifiers[algorithm]
+	mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+	return algorithm + ":" + hash, nil
+}
+
+// Verify checks a password against a stored legacy hash with the verifier
+// of its algorithm.
+func Verify(password, stored string) (bool, error) {
+	algorithm, hash := Split(stored)
+
+	mu.RLock()
+	v, ok := verifiers[algorithm]
+	mu.RUnlock()
+	if !ok {
+		return false, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+	return v.Verify(password, hash)
+}
+
+// digestVerifier checks hex digests of the password, optionally salted as
+// "<salt>$<hex digest>" where the digest is of salt followed by password.
+func digestVerifier(newHash func() hash.Hash) Verifier {
+	return VerifierFunc(func(password, stored string) (bool, error) {
+		salt, digest := "", stored
+		if i := strings.LastIndex(stored, "$"); i >= 0 {
+			salt, digest = stored[:i], stored[i+1:]
+		}
+		want, err := hex.DecodeString(digest)
+		if err != nil {
+			return false, ErrInvalidHash
+		}
+
+		h := newHash()
+		h.Write([]byte(salt + password))
+		return subtle.ConstantTimeCompare(h.Sum(nil), want) == 1, nil
+	})
+}
+
+func verifyBcrypt(password, stored string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// verifyPBKDF2SHA256 reads Django's "<iterations>$<salt>$<base64 key>".
+func verifyPBKDF2SHA256(password, stored string) (bool, error) {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 3 {
+		return false, ErrInvalidHash
+	}
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < 1 {
+		return false, ErrInvalidHash
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(want) == 0 {
+		return false, ErrInvalidHash
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, []byte(parts[1]), iterations, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1, nil
+}
@@ -0,0 +1,55 @@
+// Package metrics keeps in-process counters and writes them, together with
+// gauges computed on demand, in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// CounterVec counts events by a single label value.
+type CounterVec struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func (c *CounterVec) Inc(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = map[string]int64{}
+	}
+	c.values[label]++
+}
+
+func (c *CounterVec) Snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	values := make(map[string]int64, len(c.values))
+	for label, v := range c.values {
+		values[label] = v
+	}
+	return values
+}
+
+// LegacyPasswordMigrations counts legacy hashes replaced at login, by
+// algorithm, since the process started.
+var LegacyPasswordMigrations = &CounterVec{}
+
+// WriteFamily writes one metric family with a sample per label value.
+func WriteFamily(w io.Writer, name, kind, help, label string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, l, values[l])
+	}
+}
@@ -4,17 +4,17 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
-	"auth-service/internal/config"
+	"auth-service/internal/utils"
 
 	"context"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
 )
 
-func AuthMiddleware(cfg *config.Config, rdb *redis.Client) gin.HandlerFunc {
+func AuthMiddleware(keys *utils.KeySet, rdb *redis.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -28,24 +28,12 @@ func AuthMiddleware(cfg *config.Config, rdb *redis.Client) gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString[1], func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(cfg.JWTSecret), nil
-		})
-
+		claims, err := keys.Parse(tokenString[1])
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
-		}
-
 		// Check if metadata exists in Redis (to ensure not revoked)
 		accessUuid, ok := claims["access_uuid"].(string)
 		if !ok {
@@ -60,8 +48,88 @@ func AuthMiddleware(cfg *config.Config, rdb *redis.Client) gin.HandlerFunc {
 			return
 		}
 
-		// Set user ID in context
-		c.Set("user_id", claims["user_id"])
+		// Audience-restricted tokens from token exchange are meant for
+		// another service
+		if _, ok := claims["aud"]; ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token not intended for this service"})
+			return
+		}
+
+		// Tokens issued before subject types existed are user tokens
+		subjectType, _ := claims["subject_type"].(string)
+		if subjectType == "" {
+			subjectType = utils.SubjectUser
+		}
+
+		// Set user ID and token metadata in context
+		userID, _ := claims["user_id"].(float64)
+		familyID, _ := claims["family_id"].(string)
+		c.Set("subject_type", subjectType)
+		c.Set("user_id", uint(userID))
+		c.Set("access_uuid", accessUuid)
+		c.Set("family_id", familyID)
+		if clientID, ok := claims["client_id"].(string); ok {
+			c.Set("client_id", clientID)
+		}
+		if scope, ok := claims["scope"].(string); ok {
+			c.Set("scope", scope)
+		}
+		if acr, ok := claims["acr"].(string); ok {
+			c.Set("acr", acr)
+		}
+		if authTime, ok := claims["auth_time"].(float64); ok {
+			c.Set("auth_time", time.Unix(int64(authTime), 0))
+		}
+		c.Next()
+	}
+}
+
+// RequireACR rejects tokens whose authentication is weaker than acr or older
+// than maxAge, so the client knows to re-authenticate the user (RFC 9470). A
+// zero maxAge only checks the level. It must run after AuthMiddleware.
+func RequireACR(acr string, maxAge time.Duration) gin.HandlerFunc {
+	challenge := fmt.Sprintf(`Bearer error="insufficient_user_authentication", acr_values="%s"`, acr)
+	if maxAge > 0 {
+		challenge += fmt.Sprintf(`, max_age=%d`, int64(maxAge.Seconds()))
+	}
+
+	return func(c *gin.Context) {
+		authTime := c.GetTime("auth_time")
+		if !utils.ACRSatisfies(c.GetString("acr"), acr) || (maxAge > 0 && time.Since(authTime) > maxAge) {
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":      "insufficient_user_authentication",
+				"acr_values": acr,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUser rejects tokens issued to services through the client
+// credentials grant. It must run after AuthMiddleware.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("subject_type") != utils.SubjectUser {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User token required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireFirstParty only lets through user tokens of this service's own
+// sessions. Tokens issued to OAuth clients carry a client_id and are limited
+// to what their scope grants, so they cannot manage the user's account. It
+// must run after AuthMiddleware.
+func RequireFirstParty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, isClientToken := c.Get("client_id")
+		if c.GetString("subject_type") != utils.SubjectUser || isClientToken {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "First-party user token required"})
+			return
+		}
 		c.Next()
 	}
 }
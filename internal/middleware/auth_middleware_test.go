@@ -0,0 +1,91 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth-service/internal/middleware"
+	"auth-service/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// serve runs handler behind a stub of AuthMiddleware that sets the given
+// token metadata.
+func serve(metadata gin.H, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		for key, value := range metadata {
+			c.Set(key, value)
+		}
+	}, handler, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func TestRequireFirstParty(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata gin.H
+		status   int
+	}{
+		{"first-party user", gin.H{"subject_type": utils.SubjectUser}, http.StatusNoContent},
+		{"OAuth client acting for a user", gin.H{"subject_type": utils.SubjectUser, "client_id": "client-1", "scope": "openid email"}, http.StatusForbidden},
+		{"client credentials", gin.H{"subject_type": utils.SubjectClient, "client_id": "client-1"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.metadata, middleware.RequireFirstParty())
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestRequireACR(t *testing.T) {
+	recent := time.Now().Add(-time.Minute)
+	stale := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name     string
+		metadata gin.H
+		maxAge   time.Duration
+		status   int
+	}{
+		{"recent MFA", gin.H{"acr": utils.ACRMFA, "auth_time": recent}, 10 * time.Minute, http.StatusNoContent},
+		{"missing acr", gin.H{"auth_time": recent}, 10 * time.Minute, http.StatusUnauthorized},
+		{"unknown acr", gin.H{"acr": "urn:example:gold", "auth_time": recent}, 10 * time.Minute, http.StatusUnauthorized},
+		{"weaker acr", gin.H{"acr": utils.ACRBasic, "auth_time": recent}, 10 * time.Minute, http.StatusUnauthorized},
+		{"stale auth_time", gin.H{"acr": utils.ACRMFA, "auth_time": stale}, 10 * time.Minute, http.StatusUnauthorized},
+		{"missing auth_time", gin.H{"acr": utils.ACRMFA}, 10 * time.Minute, http.StatusUnauthorized},
+		{"stale auth_time without maxAge", gin.H{"acr": utils.ACRMFA, "auth_time": stale}, 0, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.metadata, middleware.RequireACR(utils.ACRMFA, tt.maxAge))
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, w.Body.String(), `"error":"insufficient_user_authentication"`)
+			}
+		})
+	}
+}
+
+func TestRequireACR_Challenge(t *testing.T) {
+	// Setup
+	metadata := gin.H{"acr": utils.ACRBasic, "auth_time": time.Now()}
+
+	// Execute
+	w := serve(metadata, middleware.RequireACR(utils.ACRMFA, 10*time.Minute))
+	withoutMaxAge := serve(metadata, middleware.RequireACR(utils.ACRMFA, 0))
+
+	// Assert: RFC 9470 tells the client what to ask the user for
+	assert.Equal(t, `Bearer error="insufficient_user_authentication", acr_values="mfa", max_age=600`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, `Bearer error="insufficient_user_authentication", acr_values="mfa"`, withoutMaxAge.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AuthorizationCode is the state behind an OAuth authorization code. It lives
+// in Redis for a short time and can be redeemed once.
+type AuthorizationCode struct {
+	ClientID            string    `json:"client_id"`
+	UserID              uint      `json:"user_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scope               string    `json:"scope"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	Nonce               string    `json:"nonce,omitempty"`
+	AuthTime            time.Time `json:"auth_time"`
+	AMR                 []string  `json:"amr,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Client is a registered OAuth client. Public clients have no secret.
+type Client struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	ClientID     string         `gorm:"uniqueIndex;not null" json:"client_id"`
+	SecretHash   string         `json:"-"` // Stored as Argon2 hash
+	Name         string         `json:"name"`
+	RedirectURIs string         `json:"redirect_uris"` // Space separated
+	Scopes       string         `json:"scopes"`        // Space separated
+	GrantTypes   string         `json:"grant_types"`   // Space separated
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (c *Client) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsRedirectURI requires an exact match with a registered URI.
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+func (c *Client) AllowsScope(scope string) bool {
+	return contains(c.Scopes, scope)
+}
+
+func (c *Client) AllowsGrantType(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+func contains(list, value string) bool {
+	for _, item := range strings.Fields(list) {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization is the state of an RFC 8628 device authorization,
+// stored in Redis until the device redeems it or it expires.
+type DeviceAuthorization struct {
+	ClientID  string    `json:"client_id"`
+	Scope     string    `json:"scope"`
+	UserCode  string    `json:"user_code"`
+	Status    string    `json:"status"`
+	UserID    uint      `json:"user_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Authentication of the approving session, carried into the tokens
+	AuthTime time.Time `json:"auth_time,omitempty"`
+	AMR      []string  `json:"amr,omitempty"`
+}
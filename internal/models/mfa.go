@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// TOTPCredential is a user's authenticator app. The secret is encrypted at
+// rest and the credential only protects logins once it has been confirmed
+// with a first code.
+type TOTPCredential struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"uniqueIndex;not null" json:"user_id"`
+	EncryptedSecret string     `gorm:"not null" json:"-"`
+	ConfirmedAt     *time.Time `json:"confirmed_at"`
+	LastUsedStep    int64      `json:"-"` // Rejects replays of an accepted code
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func (c *TOTPCredential) Confirmed() bool {
+	return c.ConfirmedAt != nil
+}
+
+// RecoveryCode is a single-use code that replaces the second factor when the
+// user has lost their device. Only its SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFAChallenge is a login that passed the first factor and waits for the
+// second. It lives in Redis under the hash of the challenge token. AMR holds
+// the methods of the first factor; FamilyID is set when the challenge
+// re-authenticates an existing session instead of starting one.
+type MFAChallenge struct {
+	UserID     uint
+	AMR        []string
+	FamilyID   string
+	IP         string
+	UserAgent  string
+	DeviceName string
+	Attempts   int
+	CreatedAt  time.Time
+}
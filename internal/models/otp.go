@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// OTPChallenge is a pending passwordless login: a one-time code, and
+// optionally a magic link token, sent to the user out of band. It lives in
+// Redis keyed by the destination, so each address has one code at a time.
+type OTPChallenge struct {
+	UserID     uint
+	CodeHash   string
+	LinkHash   string
+	IP         string
+	UserAgent  string
+	DeviceName string
+	Attempts   int
+	CreatedAt  time.Time
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PasswordHistory keeps the hashes of replaced passwords so the password
+// policy can refuse their reuse.
+type PasswordHistory struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Hash      string `gorm:"not null"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Session is one login of a user, i.e. one refresh token family. Sessions
+// live in Redis next to the token metadata, not in Postgres.
+type Session struct {
+	ID              string    `json:"id"`
+	UserID          uint      `json:"-"`
+	ClientID        string    `json:"client_id,omitempty"` // Set when the session belongs to an OAuth client
+	IP              string    `json:"ip"`
+	UserAgent       string    `json:"user_agent"`
+	DeviceName      string    `json:"device_name"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastRefreshedAt time.Time `json:"last_refreshed_at"`
+	AMR             []string  `json:"amr,omitempty"` // Methods of the latest authentication
+	AuthTime        time.Time `json:"auth_time"`
+	Current         bool      `json:"current"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// TrustedDevice lets password logins from a device skip the second factor
+// until ExpiresAt. The device keeps a random token; only its SHA-256 hash is
+// stored.
+type TrustedDevice struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"-"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	Label      string     `json:"label"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (d *TrustedDevice) Expired() bool {
+	return !time.Now().Before(d.ExpiresAt)
+}
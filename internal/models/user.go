@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,10 +9,26 @@ import (
 
 type User struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
-	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
+	Email     string         `gorm:"uniqueIndex;uniqueIndex:idx_users_email_lower,expression:lower(email);not null" json:"email"`
 	Password  string         `gorm:"not null" json:"-"` // Stored as Argon2 hash
 	Name      string         `json:"name"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Phone for SMS codes, in E.164 format. It is only used as a second
+	// factor once PhoneVerifiedAt is set.
+	Phone           string     `json:"phone,omitempty"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+
+	// Hash imported from the previous system, prefixed with its algorithm
+	// (see package legacy). Password stays empty until the first login
+	// replaces it with an Argon2id hash and clears this column.
+	LegacyPasswordHash string `gorm:"index:,where:legacy_password_hash <> ''" json:"-"`
+}
+
+// NormalizeEmail returns the form emails are stored and looked up in. The
+// comparison ignores case, as most mail providers do.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
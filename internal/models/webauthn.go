@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthnCredential is a registered passkey or security key. It works as a
+// second factor after the password and, when discoverable, as a passwordless
+// login on its own.
+type WebAuthnCredential struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"index;not null" json:"-"`
+	CredentialID    []byte     `gorm:"uniqueIndex;not null" json:"-"`
+	PublicKey       []byte     `gorm:"not null" json:"-"` // COSE encoded
+	AttestationType string     `json:"-"`
+	Transports      string     `json:"transports"` // Space separated
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Flags           uint8      `json:"-"` // Raw authenticator data flags
+	Name            string     `json:"name"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+	WebAuthnMFA          = "mfa"
+)
+
+// WebAuthnSession is the state of a registration or assertion ceremony kept in
+// Redis between its begin and finish calls.
+type WebAuthnSession struct {
+	Purpose string               `json:"purpose"`
+	UserID  uint                 `json:"user_id,omitempty"`
+	Name    string               `json:"name,omitempty"`
+	Data    webauthn.SessionData `json:"data"`
+}
@@ -2,15 +2,33 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"auth-service/internal/models"
+
 	"github.com/redis/go-redis/v9"
 )
 
 type AuthRepository interface {
-	CreateAuth(userid uint, accessUuid, refreshUuid string, atExpires, rtExpires int64) error
+	CreateAuth(userid uint, familyID, accessUuid, refreshUuid string, atExpires, rtExpires int64) error
+	CreateClientAuth(clientID, accessUuid string, atExpires int64) error
+	CreateDelegatedAuth(familyID, clientID, accessUuid string, atExpires int64) error
 	FetchAuth(uuid string) (string, error)
 	DeleteAuth(uuid string) error
+	RotateAuth(userid uint, familyID, oldRefreshUuid, accessUuid, refreshUuid string, atExpires, rtExpires int64) (bool, error)
+	IsRotated(familyID, refreshUuid string) (bool, error)
+	RevokeFamily(familyID string) error
+	RevokeUserFamilies(userid uint) error
+	SaveSession(session *models.Session) error
+	TouchSession(familyID, ip, userAgent string) error
+	FetchSession(familyID string) (*models.Session, error)
+	UpdateSessionAuth(familyID string, amr []string, authTime time.Time) error
+	RetireFamilyTokens(familyID string) error
+	ListSessions(userid uint) ([]models.Session, error)
 }
 
 type authRepository struct {
@@ -21,28 +39,303 @@ func NewAuthRepository(redis *redis.Client) AuthRepository {
 	return &authRepository{redis}
 }
 
-func (r *authRepository) CreateAuth(userid uint, accessUuid, refreshUuid string, atExpires, rtExpires int64) error {
+// A refresh token family groups every token pair issued from one login.
+// family:<id> holds the current access/refresh UUIDs and family:<id>:rotated
+// the refresh UUIDs that were already exchanged.
+func familyKey(familyID string) string {
+	return fmt.Sprintf("family:%s", familyID)
+}
+
+func rotatedKey(familyID string) string {
+	return fmt.Sprintf("family:%s:rotated", familyID)
+}
+
+// family:<id>:delegated holds the access tokens exchanged for tokens of the
+// family, so they are revoked with it.
+func delegatedKey(familyID string) string {
+	return fmt.Sprintf("family:%s:delegated", familyID)
+}
+
+// user:<id>:families indexes the families of a user so they can be revoked
+// together.
+func userFamiliesKey(userid uint) string {
+	return fmt.Sprintf("user:%d:families", userid)
+}
+
+func (r *authRepository) CreateAuth(userid uint, familyID, accessUuid, refreshUuid string, atExpires, rtExpires int64) error {
 	at := time.Unix(atExpires, 0)
 	rt := time.Unix(rtExpires, 0)
 	now := time.Now()
+	ctx := context.Background()
+
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, accessUuid, userid, at.Sub(now))
+		pipe.Set(ctx, refreshUuid, userid, rt.Sub(now))
+		pipe.HSet(ctx, familyKey(familyID), "user_id", userid, "access_uuid", accessUuid, "refresh_uuid", refreshUuid)
+		pipe.ExpireAt(ctx, familyKey(familyID), rt)
+		pipe.ExpireAt(ctx, rotatedKey(familyID), rt)
+		pipe.SAdd(ctx, userFamiliesKey(userid), familyID)
+		pipe.ExpireAt(ctx, userFamiliesKey(userid), rt)
+		return nil
+	})
+	return err
+}
+
+// CreateClientAuth stores the metadata of an access token issued to a client
+// through the client credentials grant.
+func (r *authRepository) CreateClientAuth(clientID, accessUuid string, atExpires int64) error {
+	at := time.Unix(atExpires, 0)
+	return r.redis.Set(context.Background(), accessUuid, "client:"+clientID, time.Until(at)).Err()
+}
+
+// CreateDelegatedAuth stores an access token a client obtained through token
+// exchange for a token of the family.
+func (r *authRepository) CreateDelegatedAuth(familyID, clientID, accessUuid string, atExpires int64) error {
+	at := time.Unix(atExpires, 0)
+	ctx := context.Background()
+
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, accessUuid, "client:"+clientID, time.Until(at))
+		pipe.SAdd(ctx, delegatedKey(familyID), accessUuid)
+		// Keep the index until its longest-lived token expires
+		pipe.ExpireNX(ctx, delegatedKey(familyID), time.Until(at))
+		pipe.ExpireGT(ctx, delegatedKey(familyID), time.Until(at))
+		return nil
+	})
+	return err
+}
 
-	// userIdStr := string(rune(userid)) // Warning: check casting, usually fmt.Sprint(userid)
+func (r *authRepository) FetchAuth(uuid string) (string, error) {
+	return r.redis.Get(context.Background(), uuid).Result()
+}
+
+func (r *authRepository) DeleteAuth(uuid string) error {
+	return r.redis.Del(context.Background(), uuid).Err()
+}
+
+// rotateAuth swaps the token pair of a family in one step. It writes nothing
+// unless the family still exists and the presented refresh token was not
+// used yet, so a revocation racing with a refresh cannot be undone by it.
+var rotateAuth = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if redis.call("DEL", ARGV[1]) == 0 then
+	return 0
+end
+-- The previous access token of the family is retired together with its refresh token
+local access = redis.call("HGET", KEYS[1], "access_uuid")
+if access and access ~= "" then
+	redis.call("DEL", access)
+end
+redis.call("SADD", KEYS[2], ARGV[1])
+
+redis.call("SET", ARGV[3], ARGV[2], "EXAT", ARGV[5])
+redis.call("SET", ARGV[4], ARGV[2], "EXAT", ARGV[6])
+redis.call("HSET", KEYS[1], "access_uuid", ARGV[3], "refresh_uuid", ARGV[4])
+redis.call("EXPIREAT", KEYS[1], ARGV[6])
+redis.call("EXPIREAT", KEYS[2], ARGV[6])
+redis.call("SADD", KEYS[3], ARGV[7])
+redis.call("EXPIREAT", KEYS[3], ARGV[6])
+return 1
+`)
 
-	err := r.redis.Set(context.Background(), accessUuid, userid, at.Sub(now)).Err()
+// RotateAuth consumes a refresh token of the family and stores the new token
+// pair. It returns false when the token no longer exists or the family was
+// revoked, so only one caller can win a rotation.
+func (r *authRepository) RotateAuth(userid uint, familyID, oldRefreshUuid, accessUuid, refreshUuid string, atExpires, rtExpires int64) (bool, error) {
+	keys := []string{familyKey(familyID), rotatedKey(familyID), userFamiliesKey(userid)}
+	rotated, err := rotateAuth.Run(context.Background(), r.redis, keys,
+		oldRefreshUuid, userid, accessUuid, refreshUuid, atExpires, rtExpires, familyID).Int()
+	return rotated == 1, err
+}
+
+func (r *authRepository) IsRotated(familyID, refreshUuid string) (bool, error) {
+	return r.redis.SIsMember(context.Background(), rotatedKey(familyID), refreshUuid).Result()
+}
+
+// RevokeFamily deletes the live tokens of the family, including the tokens
+// exchanged for them. The rotated set is kept until it expires so later
+// replays are still recognised.
+func (r *authRepository) RevokeFamily(familyID string) error {
+	ctx := context.Background()
+
+	family, err := r.redis.HGetAll(ctx, familyKey(familyID)).Result()
 	if err != nil {
 		return err
 	}
-	err = r.redis.Set(context.Background(), refreshUuid, userid, rt.Sub(now)).Err()
+	delegated, err := r.redis.SMembers(ctx, delegatedKey(familyID)).Result()
 	if err != nil {
 		return err
 	}
-	return nil
+
+	_, err = r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, field := range []string{"access_uuid", "refresh_uuid"} {
+			if uuid := family[field]; uuid != "" {
+				pipe.Del(ctx, uuid)
+			}
+		}
+		for _, uuid := range delegated {
+			pipe.Del(ctx, uuid)
+		}
+		pipe.Del(ctx, familyKey(familyID), delegatedKey(familyID))
+		if userid, err := strconv.ParseUint(family["user_id"], 10, 64); err == nil {
+			pipe.SRem(ctx, userFamiliesKey(uint(userid)), familyID)
+		}
+		return nil
+	})
+	return err
 }
 
-func (r *authRepository) FetchAuth(uuid string) (string, error) {
-	return r.redis.Get(context.Background(), uuid).Result()
+func (r *authRepository) RevokeUserFamilies(userid uint) error {
+	ctx := context.Background()
+
+	familyIDs, err := r.redis.SMembers(ctx, userFamiliesKey(userid)).Result()
+	if err != nil {
+		return err
+	}
+	for _, familyID := range familyIDs {
+		if err := r.RevokeFamily(familyID); err != nil {
+			return err
+		}
+	}
+	return r.redis.Del(ctx, userFamiliesKey(userid)).Err()
 }
 
-func (r *authRepository) DeleteAuth(uuid string) error {
-	return r.redis.Del(context.Background(), uuid).Err()
+// hsetIfExists sets hash fields only when the hash still exists. A plain
+// HSET racing with RevokeFamily would recreate the family without a TTL.
+var hsetIfExists = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+return redis.call("HSET", KEYS[1], unpack(ARGV))
+`)
+
+func (r *authRepository) updateFamily(familyID string, values ...interface{}) error {
+	return hsetIfExists.Run(context.Background(), r.redis, []string{familyKey(familyID)}, values...).Err()
+}
+
+// SaveSession stores the session metadata on the family hash, so it expires
+// and is revoked together with the tokens.
+func (r *authRepository) SaveSession(session *models.Session) error {
+	return r.updateFamily(session.ID,
+		"user_id", session.UserID,
+		"client_id", session.ClientID,
+		"ip", session.IP,
+		"user_agent", session.UserAgent,
+		"device_name", session.DeviceName,
+		"created_at", session.CreatedAt.Unix(),
+		"last_refreshed_at", session.LastRefreshedAt.Unix(),
+		"amr", strings.Join(session.AMR, " "),
+		"auth_time", session.AuthTime.Unix(),
+	)
+}
+
+// UpdateSessionAuth records a re-authentication of the session.
+func (r *authRepository) UpdateSessionAuth(familyID string, amr []string, authTime time.Time) error {
+	return r.updateFamily(familyID,
+		"amr", strings.Join(amr, " "),
+		"auth_time", authTime.Unix(),
+	)
+}
+
+// retireFamilyTokens deletes the current token pair of the family and marks
+// its refresh token as rotated. It runs as a script so that a concurrent
+// refresh cannot swap the pair between reading and deleting it.
+var retireFamilyTokens = redis.NewScript(`
+local access = redis.call("HGET", KEYS[1], "access_uuid")
+if access and access ~= "" then
+	redis.call("DEL", access)
+end
+local refresh = redis.call("HGET", KEYS[1], "refresh_uuid")
+if refresh and refresh ~= "" then
+	redis.call("DEL", refresh)
+	redis.call("SADD", KEYS[2], refresh)
+	local ttl = redis.call("TTL", KEYS[1])
+	if ttl > 0 then
+		redis.call("EXPIRE", KEYS[2], ttl)
+	end
+end
+return 0
+`)
+
+// RetireFamilyTokens ends the current token pair of the family before a new
+// one is issued outside of a refresh. The refresh token is marked as rotated,
+// so presenting it later counts as reuse.
+func (r *authRepository) RetireFamilyTokens(familyID string) error {
+	keys := []string{familyKey(familyID), rotatedKey(familyID)}
+	return retireFamilyTokens.Run(context.Background(), r.redis, keys).Err()
+}
+
+func (r *authRepository) TouchSession(familyID, ip, userAgent string) error {
+	return r.updateFamily(familyID,
+		"ip", ip,
+		"user_agent", userAgent,
+		"last_refreshed_at", time.Now().Unix(),
+	)
+}
+
+func (r *authRepository) FetchSession(familyID string) (*models.Session, error) {
+	family, err := r.redis.HGetAll(context.Background(), familyKey(familyID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(family) == 0 {
+		return nil, redis.Nil
+	}
+	return sessionFromHash(familyID, family), nil
+}
+
+func (r *authRepository) ListSessions(userid uint) ([]models.Session, error) {
+	ctx := context.Background()
+
+	familyIDs, err := r.redis.SMembers(ctx, userFamiliesKey(userid)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]models.Session, 0, len(familyIDs))
+	for _, familyID := range familyIDs {
+		session, err := r.FetchSession(familyID)
+		if err == redis.Nil {
+			// Family expired, drop it from the index
+			r.redis.SRem(ctx, userFamiliesKey(userid), familyID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastRefreshedAt.After(sessions[j].LastRefreshedAt)
+	})
+	return sessions, nil
+}
+
+func sessionFromHash(familyID string, family map[string]string) *models.Session {
+	userid, _ := strconv.ParseUint(family["user_id"], 10, 64)
+	createdAt, _ := strconv.ParseInt(family["created_at"], 10, 64)
+	refreshedAt, _ := strconv.ParseInt(family["last_refreshed_at"], 10, 64)
+
+	// Sessions started before authentication times were recorded
+	// authenticated when they were created
+	authTime := createdAt
+	if value, ok := family["auth_time"]; ok {
+		authTime, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	return &models.Session{
+		ID:              familyID,
+		UserID:          uint(userid),
+		ClientID:        family["client_id"],
+		IP:              family["ip"],
+		UserAgent:       family["user_agent"],
+		DeviceName:      family["device_name"],
+		CreatedAt:       time.Unix(createdAt, 0).UTC(),
+		LastRefreshedAt: time.Unix(refreshedAt, 0).UTC(),
+		AMR:             strings.Fields(family["amr"]),
+		AuthTime:        time.Unix(authTime, 0).UTC(),
+	}
 }
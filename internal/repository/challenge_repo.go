@@ -0,0 +1,305 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChallengeRepository keeps the short-lived state of logins that are waiting
+// for a second factor or a one-time code.
+type ChallengeRepository interface {
+	SaveMFAChallenge(token string, challenge *models.MFAChallenge, ttl time.Duration) error
+	FetchMFAChallenge(token string) (*models.MFAChallenge, error)
+	RecordMFAAttempt(token string) (int64, error)
+	DeleteMFAChallenge(token string) (bool, error)
+	CountMFAFailures(userID uint) (int64, error)
+	RecordMFAFailure(userID uint, window time.Duration) (int64, error)
+	ResetMFAFailures(userID uint) error
+	SaveWebAuthnSession(id string, session *models.WebAuthnSession, ttl time.Duration) error
+	ConsumeWebAuthnSession(id string) (*models.WebAuthnSession, error)
+	SaveOTPChallenge(destination string, challenge *models.OTPChallenge, ttl time.Duration) error
+	FetchOTPChallenge(destination string) (*models.OTPChallenge, error)
+	RecordOTPAttempt(destination string) (int64, error)
+	ForgiveOTPAttempt(destination string) error
+	CountOTPFailures(destination string) (int64, error)
+	RecordOTPFailure(destination string, window time.Duration) (int64, error)
+	ResetOTPFailures(destination string) error
+	DeleteOTPChallenge(destination string) (bool, error)
+	ThrottleOTP(destination string, interval time.Duration) (bool, error)
+}
+
+type challengeRepository struct {
+	redis *redis.Client
+}
+
+func NewChallengeRepository(redis *redis.Client) ChallengeRepository {
+	return &challengeRepository{redis}
+}
+
+// Challenge tokens are bearer secrets, so only their hash is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func mfaChallengeKey(token string) string {
+	return fmt.Sprintf("mfa:challenge:%s", hashToken(token))
+}
+
+// mfa:failures:<id> counts the failed second factors of a user across all
+// of their challenges.
+func mfaFailuresKey(userID uint) string {
+	return fmt.Sprintf("mfa:failures:%d", userID)
+}
+
+func webAuthnSessionKey(id string) string {
+	return fmt.Sprintf("webauthn:session:%s", hashToken(id))
+}
+
+// Destinations are addresses such as "email:john@example.com"; hashing keeps
+// them out of Redis key listings.
+func otpChallengeKey(destination string) string {
+	return fmt.Sprintf("otp:challenge:%s", hashToken(destination))
+}
+
+func otpThrottleKey(destination string) string {
+	return fmt.Sprintf("otp:throttle:%s", hashToken(destination))
+}
+
+// otp:failures:<hash> counts the wrong codes sent for a destination. Unlike
+// the attempts of a challenge, it survives sending a new code.
+func otpFailuresKey(destination string) string {
+	return fmt.Sprintf("otp:failures:%s", hashToken(destination))
+}
+
+func (r *challengeRepository) SaveMFAChallenge(token string, challenge *models.MFAChallenge, ttl time.Duration) error {
+	ctx := context.Background()
+	key := mfaChallengeKey(token)
+
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", challenge.UserID,
+			"amr", strings.Join(challenge.AMR, " "),
+			"family_id", challenge.FamilyID,
+			"ip", challenge.IP,
+			"user_agent", challenge.UserAgent,
+			"device_name", challenge.DeviceName,
+			"attempts", challenge.Attempts,
+			"created_at", challenge.CreatedAt.Unix(),
+		)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *challengeRepository) FetchMFAChallenge(token string) (*models.MFAChallenge, error) {
+	fields, err := r.redis.HGetAll(context.Background(), mfaChallengeKey(token)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, redis.Nil
+	}
+
+	userID, _ := strconv.ParseUint(fields["user_id"], 10, 64)
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	return &models.MFAChallenge{
+		UserID:     uint(userID),
+		AMR:        strings.Fields(fields["amr"]),
+		FamilyID:   fields["family_id"],
+		IP:         fields["ip"],
+		UserAgent:  fields["user_agent"],
+		DeviceName: fields["device_name"],
+		Attempts:   attempts,
+		CreatedAt:  time.Unix(createdAt, 0),
+	}, nil
+}
+
+// RecordMFAAttempt counts a verification attempt and returns the new total,
+// or 0 when the challenge has expired in the meantime.
+func (r *challengeRepository) RecordMFAAttempt(token string) (int64, error) {
+	return hincrIfExists.Run(context.Background(), r.redis, []string{mfaChallengeKey(token)}, "attempts", 1).Int64()
+}
+
+// DeleteMFAChallenge returns true only for the caller that deleted it, so a
+// challenge completes once.
+func (r *challengeRepository) DeleteMFAChallenge(token string) (bool, error) {
+	deleted, err := r.redis.Del(context.Background(), mfaChallengeKey(token)).Result()
+	return deleted == 1, err
+}
+
+func (r *challengeRepository) CountMFAFailures(userID uint) (int64, error) {
+	count, err := r.redis.Get(context.Background(), mfaFailuresKey(userID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// RecordMFAFailure counts a failed second factor and returns the failures
+// within the window, which starts with the first failure.
+func (r *challengeRepository) RecordMFAFailure(userID uint, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	key := mfaFailuresKey(userID)
+
+	var incr *redis.IntCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *challengeRepository) ResetMFAFailures(userID uint) error {
+	return r.redis.Del(context.Background(), mfaFailuresKey(userID)).Err()
+}
+
+func (r *challengeRepository) SaveWebAuthnSession(id string, session *models.WebAuthnSession, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(context.Background(), webAuthnSessionKey(id), data, ttl).Err()
+}
+
+// ConsumeWebAuthnSession deletes the session while reading it, so every
+// challenge can be answered only once.
+func (r *challengeRepository) ConsumeWebAuthnSession(id string) (*models.WebAuthnSession, error) {
+	data, err := r.redis.GetDel(context.Background(), webAuthnSessionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.WebAuthnSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SaveOTPChallenge replaces any pending code for the destination, resetting
+// its attempt counter.
+func (r *challengeRepository) SaveOTPChallenge(destination string, challenge *models.OTPChallenge, ttl time.Duration) error {
+	ctx := context.Background()
+	key := otpChallengeKey(destination)
+
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", challenge.UserID,
+			"code_hash", challenge.CodeHash,
+			"link_hash", challenge.LinkHash,
+			"ip", challenge.IP,
+			"user_agent", challenge.UserAgent,
+			"device_name", challenge.DeviceName,
+			"attempts", challenge.Attempts,
+			"created_at", challenge.CreatedAt.Unix(),
+		)
+		pipe.Expire(ctx, key, ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *challengeRepository) FetchOTPChallenge(destination string) (*models.OTPChallenge, error) {
+	fields, err := r.redis.HGetAll(context.Background(), otpChallengeKey(destination)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, redis.Nil
+	}
+
+	userID, _ := strconv.ParseUint(fields["user_id"], 10, 64)
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	return &models.OTPChallenge{
+		UserID:     uint(userID),
+		CodeHash:   fields["code_hash"],
+		LinkHash:   fields["link_hash"],
+		IP:         fields["ip"],
+		UserAgent:  fields["user_agent"],
+		DeviceName: fields["device_name"],
+		Attempts:   attempts,
+		CreatedAt:  time.Unix(createdAt, 0),
+	}, nil
+}
+
+// RecordOTPAttempt counts a verification attempt and returns the new total,
+// or 0 when the challenge has expired in the meantime.
+func (r *challengeRepository) RecordOTPAttempt(destination string) (int64, error) {
+	return hincrIfExists.Run(context.Background(), r.redis, []string{otpChallengeKey(destination)}, "attempts", 1).Int64()
+}
+
+// hincrIfExists increments a hash field only when the hash still exists, so
+// a challenge deleted in the meantime is not recreated without a TTL.
+var hincrIfExists = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+return redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2])
+`)
+
+// ForgiveOTPAttempt takes back the attempt recorded for a secret that
+// matched.
+func (r *challengeRepository) ForgiveOTPAttempt(destination string) error {
+	return hincrIfExists.Run(context.Background(), r.redis, []string{otpChallengeKey(destination)}, "attempts", -1).Err()
+}
+
+// DeleteOTPChallenge returns true only for the caller that deleted it, so a
+// code logs in once.
+func (r *challengeRepository) DeleteOTPChallenge(destination string) (bool, error) {
+	deleted, err := r.redis.Del(context.Background(), otpChallengeKey(destination)).Result()
+	return deleted == 1, err
+}
+
+func (r *challengeRepository) CountOTPFailures(destination string) (int64, error) {
+	count, err := r.redis.Get(context.Background(), otpFailuresKey(destination)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+// RecordOTPFailure counts a wrong code for the destination and returns the
+// failures within the window, which starts with the first failure.
+func (r *challengeRepository) RecordOTPFailure(destination string, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	key := otpFailuresKey(destination)
+
+	var incr *redis.IntCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *challengeRepository) ResetOTPFailures(destination string) error {
+	return r.redis.Del(context.Background(), otpFailuresKey(destination)).Err()
+}
+
+// ThrottleOTP returns false when a code was already sent to the destination
+// within the interval.
+func (r *challengeRepository) ThrottleOTP(destination string, interval time.Duration) (bool, error) {
+	return r.redis.SetNX(context.Background(), otpThrottleKey(destination), 1, interval).Result()
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"auth-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type ClientRepository interface {
+	CreateClient(client *models.Client) error
+	FindByClientID(clientID string) (*models.Client, error)
+	RehashSecret(id uint, oldHash, newHash string) (bool, error)
+	CountSecretPeppers() (map[string]int64, error)
+}
+
+type clientRepository struct {
+	db *gorm.DB
+}
+
+func NewClientRepository(db *gorm.DB) ClientRepository {
+	return &clientRepository{db}
+}
+
+func (r *clientRepository) CreateClient(client *models.Client) error {
+	return r.db.Create(client).Error
+}
+
+func (r *clientRepository) FindByClientID(clientID string) (*models.Client, error) {
+	var client models.Client
+	err := r.db.Where("client_id = ?", clientID).First(&client).Error
+	return &client, err
+}
+
+// RehashSecret swaps the hash of an unchanged client secret for a new one.
+// It does nothing when the secret was changed in the meantime.
+func (r *clientRepository) RehashSecret(id uint, oldHash, newHash string) (bool, error) {
+	result := r.db.Model(&models.Client{}).Where("id = ? AND secret_hash = ?", id, oldHash).Update("secret_hash", newHash)
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountSecretPeppers counts the confidential clients by the pepper version of
+// their secret hash. Unpeppered hashes count under "".
+func (r *clientRepository) CountSecretPeppers() (map[string]int64, error) {
+	return countPepperVersions(r.db.Model(&models.Client{}), "secret_hash")
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"time"
+
+	"auth-service/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type MFARepository interface {
+	FindTOTP(userID uint) (*models.TOTPCredential, error)
+	SaveTOTP(cred *models.TOTPCredential) error
+	DeleteTOTP(userID uint) error
+	UseTOTPStep(userID uint, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID uint, hashes []string) error
+	UseRecoveryCode(userID uint, hash string) (bool, error)
+	ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error)
+	SaveWebAuthnCredential(cred *models.WebAuthnCredential) error
+	DeleteWebAuthnCredential(userID, id uint) (bool, error)
+	SaveTrustedDevice(device *models.TrustedDevice) error
+	FindTrustedDevice(tokenHash string) (*models.TrustedDevice, error)
+	ListTrustedDevices(userID uint) ([]models.TrustedDevice, error)
+	DeleteTrustedDevice(userID, id uint) (bool, error)
+	DeleteTrustedDevices(userID uint) error
+}
+
+type mfaRepository struct {
+	db *gorm.DB
+}
+
+func NewMFARepository(db *gorm.DB) MFARepository {
+	return &mfaRepository{db}
+}
+
+func (r *mfaRepository) FindTOTP(userID uint) (*models.TOTPCredential, error) {
+	var cred models.TOTPCredential
+	err := r.db.Where("user_id = ?", userID).First(&cred).Error
+	return &cred, err
+}
+
+func (r *mfaRepository) SaveTOTP(cred *models.TOTPCredential) error {
+	return r.db.Save(cred).Error
+}
+
+func (r *mfaRepository) DeleteTOTP(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.TOTPCredential{}).Error
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false when
+// that step or a later one was already used, so a code works only once.
+func (r *mfaRepository) UseTOTPStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&models.TOTPCredential{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceRecoveryCodes invalidates the previous codes of the user.
+func (r *mfaRepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+
+		codes := make([]models.RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode marks an unused code as used. Only one caller can win.
+func (r *mfaRepository) UseRecoveryCode(userID uint, hash string) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *mfaRepository) ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	var creds []models.WebAuthnCredential
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&creds).Error
+	return creds, err
+}
+
+func (r *mfaRepository) SaveWebAuthnCredential(cred *models.WebAuthnCredential) error {
+	return r.db.Save(cred).Error
+}
+
+// DeleteWebAuthnCredential only deletes credentials of the given user.
+func (r *mfaRepository) DeleteWebAuthnCredential(userID, id uint) (bool, error) {
+	result := r.db.Where("user_id = ? AND id = ?", userID, id).Delete(&models.WebAuthnCredential{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *mfaRepository) SaveTrustedDevice(device *models.TrustedDevice) error {
+	return r.db.Save(device).Error
+}
+
+func (r *mfaRepository) FindTrustedDevice(tokenHash string) (*models.TrustedDevice, error) {
+	var device models.TrustedDevice
+	err := r.db.Where("token_hash = ?", tokenHash).First(&device).Error
+	return &device, err
+}
+
+// ListTrustedDevices skips devices whose trust has expired.
+func (r *mfaRepository) ListTrustedDevices(userID uint) ([]models.TrustedDevice, error) {
+	var devices []models.TrustedDevice
+	err := r.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("created_at").Find(&devices).Error
+	return devices, err
+}
+
+// DeleteTrustedDevice only deletes devices of the given user.
+func (r *mfaRepository) DeleteTrustedDevice(userID, id uint) (bool, error) {
+	result := r.db.Where("user_id = ? AND id = ?", userID, id).Delete(&models.TrustedDevice{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *mfaRepository) DeleteTrustedDevices(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.TrustedDevice{}).Error
+}
@@ -1,6 +1,10 @@
 package mocks
 
 import (
+	"time"
+
+	"auth-service/internal/models"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -8,8 +12,18 @@ type MockAuthRepository struct {
 	mock.Mock
 }
 
-func (m *MockAuthRepository) CreateAuth(userid uint, accessUuid, refreshUuid string, atExpires, rtExpires int64) error {
-	args := m.Called(userid, accessUuid, refreshUuid, atExpires, rtExpires)
+func (m *MockAuthRepository) CreateAuth(userid uint, familyID, accessUuid, refreshUuid string, atExpires, rtExpires int64) error {
+	args := m.Called(userid, familyID, accessUuid, refreshUuid, atExpires, rtExpires)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) CreateClientAuth(clientID, accessUuid string, atExpires int64) error {
+	args := m.Called(clientID, accessUuid, atExpires)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) CreateDelegatedAuth(familyID, clientID, accessUuid string, atExpires int64) error {
+	args := m.Called(familyID, clientID, accessUuid, atExpires)
 	return args.Error(0)
 }
 
@@ -22,3 +36,59 @@ func (m *MockAuthRepository) DeleteAuth(uuid string) error {
 	args := m.Called(uuid)
 	return args.Error(0)
 }
+
+func (m *MockAuthRepository) RotateAuth(userid uint, familyID, oldRefreshUuid, accessUuid, refreshUuid string, atExpires, rtExpires int64) (bool, error) {
+	args := m.Called(userid, familyID, oldRefreshUuid, accessUuid, refreshUuid, atExpires, rtExpires)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAuthRepository) IsRotated(familyID, refreshUuid string) (bool, error) {
+	args := m.Called(familyID, refreshUuid)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAuthRepository) RevokeFamily(familyID string) error {
+	args := m.Called(familyID)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) RevokeUserFamilies(userid uint) error {
+	args := m.Called(userid)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) SaveSession(session *models.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) TouchSession(familyID, ip, userAgent string) error {
+	args := m.Called(familyID, ip, userAgent)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) FetchSession(familyID string) (*models.Session, error) {
+	args := m.Called(familyID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Session), args.Error(1)
+}
+
+func (m *MockAuthRepository) ListSessions(userid uint) ([]models.Session, error) {
+	args := m.Called(userid)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Session), args.Error(1)
+}
+
+func (m *MockAuthRepository) UpdateSessionAuth(familyID string, amr []string, authTime time.Time) error {
+	args := m.Called(familyID, amr, authTime)
+	return args.Error(0)
+}
+
+func (m *MockAuthRepository) RetireFamilyTokens(familyID string) error {
+	args := m.Called(familyID)
+	return args.Error(0)
+}
@@ -0,0 +1,112 @@
+package mocks
+
+import (
+	"time"
+
+	"auth-service/internal/models"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockChallengeRepository struct {
+	mock.Mock
+}
+
+func (m *MockChallengeRepository) SaveMFAChallenge(token string, challenge *models.MFAChallenge, ttl time.Duration) error {
+	args := m.Called(token, challenge, ttl)
+	return args.Error(0)
+}
+
+func (m *MockChallengeRepository) FetchMFAChallenge(token string) (*models.MFAChallenge, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.MFAChallenge), args.Error(1)
+}
+
+func (m *MockChallengeRepository) RecordMFAAttempt(token string) (int64, error) {
+	args := m.Called(token)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockChallengeRepository) DeleteMFAChallenge(token string) (bool, error) {
+	args := m.Called(token)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockChallengeRepository) CountMFAFailures(userID uint) (int64, error) {
+	args := m.Called(userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockChallengeRepository) RecordMFAFailure(userID uint, window time.Duration) (int64, error) {
+	args := m.Called(userID, window)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockChallengeRepository) ResetMFAFailures(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockChallengeRepository) SaveWebAuthnSession(id string, session *models.WebAuthnSession, ttl time.Duration) error {
+	args := m.Called(id, session, ttl)
+	return args.Error(0)
+}
+
+func (m *MockChallengeRepository) ConsumeWebAuthnSession(id string) (*models.WebAuthnSession, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WebAuthnSession), args.Error(1)
+}
+
+func (m *MockChallengeRepository) SaveOTPChallenge(destination string, challenge *models.OTPChallenge, ttl time.Duration) error {
+	args := m.Called(destination, challenge, ttl)
+	return args.Error(0)
+}
+
+func (m *MockChallengeRepository) FetchOTPChallenge(destination string) (*models.OTPChallenge, error) {
+	args := m.Called(destination)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OTPChallenge), args.Error(1)
+}
+
+func (m *MockChallengeRepository) RecordOTPAttempt(destination string) (int64, error) {
+	args := m.Called(destination)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockChallengeRepository) ForgiveOTPAttempt(destination string) error {
+	args := m.Called(destination)
+	return args.Error(0)
+}
+
+func (m *MockChallengeRepository) DeleteOTPChallenge(destination string) (bool, error) {
+	args := m.Called(destination)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockChallengeRepository) ThrottleOTP(destination string, interval time.Duration) (bool, error) {
+	args := m.Called(destination, interval)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockChallengeRepository) CountOTPFailures(destination string) (int64, error) {
+	args := m.Called(destination)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockChallengeRepository) RecordOTPFailure(destination string, window time.Duration) (int64, error) {
+	args := m.Called(destination, window)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockChallengeRepository) ResetOTPFailures(destination string) error {
+	args := m.Called(destination)
+	return args.Error(0)
+}
@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"auth-service/internal/models"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockClientRepository struct {
+	mock.Mock
+}
+
+func (m *MockClientRepository) CreateClient(client *models.Client) error {
+	args := m.Called(client)
+	return args.Error(0)
+}
+
+func (m *MockClientRepository) FindByClientID(clientID string) (*models.Client, error) {
+	args := m.Called(clientID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Client), args.Error(1)
+}
+
+func (m *MockClientRepository) RehashSecret(id uint, oldHash, newHash string) (bool, error) {
+	args := m.Called(id, oldHash, newHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockClientRepository) CountSecretPeppers() (map[string]int64, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
@@ -0,0 +1,93 @@
+package mocks
+
+import (
+	"auth-service/internal/models"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockMFARepository struct {
+	mock.Mock
+}
+
+func (m *MockMFARepository) FindTOTP(userID uint) (*models.TOTPCredential, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.TOTPCredential), args.Error(1)
+}
+
+func (m *MockMFARepository) SaveTOTP(cred *models.TOTPCredential) error {
+	args := m.Called(cred)
+	return args.Error(0)
+}
+
+func (m *MockMFARepository) DeleteTOTP(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockMFARepository) UseTOTPStep(userID uint, step int64) (bool, error) {
+	args := m.Called(userID, step)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMFARepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	args := m.Called(userID, hashes)
+	return args.Error(0)
+}
+
+func (m *MockMFARepository) UseRecoveryCode(userID uint, hash string) (bool, error) {
+	args := m.Called(userID, hash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMFARepository) ListWebAuthnCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.WebAuthnCredential), args.Error(1)
+}
+
+func (m *MockMFARepository) SaveWebAuthnCredential(cred *models.WebAuthnCredential) error {
+	args := m.Called(cred)
+	return args.Error(0)
+}
+
+func (m *MockMFARepository) DeleteWebAuthnCredential(userID, id uint) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMFARepository) SaveTrustedDevice(device *models.TrustedDevice) error {
+	args := m.Called(device)
+	return args.Error(0)
+}
+
+func (m *MockMFARepository) FindTrustedDevice(tokenHash string) (*models.TrustedDevice, error) {
+	args := m.Called(tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.TrustedDevice), args.Error(1)
+}
+
+func (m *MockMFARepository) ListTrustedDevices(userID uint) ([]models.TrustedDevice, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.TrustedDevice), args.Error(1)
+}
+
+func (m *MockMFARepository) DeleteTrustedDevice(userID, id uint) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMFARepository) DeleteTrustedDevices(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
@@ -0,0 +1,62 @@
+package mocks
+
+import (
+	"time"
+
+	"auth-service/internal/models"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MockOAuthRepository struct {
+	mock.Mock
+}
+
+func (m *MockOAuthRepository) SaveAuthCode(code string, authCode *models.AuthorizationCode, ttl time.Duration) error {
+	args := m.Called(code, authCode, ttl)
+	return args.Error(0)
+}
+
+func (m *MockOAuthRepository) ConsumeAuthCode(code string) (*models.AuthorizationCode, error) {
+	args := m.Called(code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.AuthorizationCode), args.Error(1)
+}
+
+func (m *MockOAuthRepository) SaveDeviceAuthorization(deviceCode string, auth *models.DeviceAuthorization, ttl time.Duration) error {
+	args := m.Called(deviceCode, auth, ttl)
+	return args.Error(0)
+}
+
+func (m *MockOAuthRepository) FindDeviceAuthorization(userCode string) (*models.DeviceAuthorization, error) {
+	args := m.Called(userCode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.DeviceAuthorization), args.Error(1)
+}
+
+func (m *MockOAuthRepository) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	args := m.Called(auth)
+	return args.Error(0)
+}
+
+func (m *MockOAuthRepository) FetchDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	args := m.Called(deviceCode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.DeviceAuthorization), args.Error(1)
+}
+
+func (m *MockOAuthRepository) ConsumeDeviceAuthorization(deviceCode string) (bool, error) {
+	args := m.Called(deviceCode)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockOAuthRepository) ThrottleDevicePoll(deviceCode string, interval time.Duration) (bool, error) {
+	args := m.Called(deviceCode, interval)
+	return args.Bool(0), args.Error(1)
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"time"
+
 	"auth-service/internal/models"
 
 	"github.com/stretchr/testify/mock"
@@ -30,3 +32,60 @@ func (m *MockUserRepository) FindByID(id uint) (*models.User, error) {
 	}
 	return args.Get(0).(*models.User), args.Error(1)
 }
+
+func (m *MockUserRepository) UpdatePhone(id uint, phone string, verifiedAt *time.Time) error {
+	args := m.Called(id, phone, verifiedAt)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UpdatePassword(id uint, hash string, keepHistory int) error {
+	args := m.Called(id, hash, keepHistory)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) ListPasswordHistory(id uint, limit int) ([]string, error) {
+	args := m.Called(id, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockUserRepository) RehashPassword(id uint, oldHash, newHash string) (bool, error) {
+	args := m.Called(id, oldHash, newHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) MigrateLegacyPassword(id uint, legacyHash, newHash string) (bool, error) {
+	args := m.Called(id, legacyHash, newHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) CountLegacyPasswords() (map[string]int64, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (m *MockUserRepository) CountPasswordPeppers() (map[string]int64, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (m *MockUserRepository) CountHistoryPeppers() (map[string]int64, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (m *MockUserRepository) UpsertImportedUsers(users []models.User) (int64, error) {
+	args := m.Called(users)
+	return args.Get(0).(int64), args.Error(1)
+}
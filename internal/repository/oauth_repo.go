@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"auth-service/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type OAuthRepository interface {
+	SaveAuthCode(code string, authCode *models.AuthorizationCode, ttl time.Duration) error
+	ConsumeAuthCode(code string) (*models.AuthorizationCode, error)
+	SaveDeviceAuthorization(deviceCode string, auth *models.DeviceAuthorization, ttl time.Duration) error
+	FindDeviceAuthorization(userCode string) (*models.DeviceAuthorization, error)
+	UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error
+	FetchDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error)
+	ConsumeDeviceAuthorization(deviceCode string) (bool, error)
+	ThrottleDevicePoll(deviceCode string, interval time.Duration) (bool, error)
+}
+
+type oauthRepository struct {
+	redis *redis.Client
+}
+
+func NewOAuthRepository(redis *redis.Client) OAuthRepository {
+	return &oauthRepository{redis}
+}
+
+// Codes are only stored hashed, so a Redis dump does not leak usable codes.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func authCodeKey(code string) string {
+	return fmt.Sprintf("oauth:code:%s", hashCode(code))
+}
+
+// A device authorization is stored under its user code, which the approving
+// user types in; the device code only points to it.
+func userCodeKey(userCode string) string {
+	return fmt.Sprintf("oauth:user_code:%s", userCode)
+}
+
+func deviceCodeKey(deviceCode string) string {
+	return fmt.Sprintf("oauth:device:%s", hashCode(deviceCode))
+}
+
+func (r *oauthRepository) SaveAuthCode(code string, authCode *models.AuthorizationCode, ttl time.Duration) error {
+	data, err := json.Marshal(authCode)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(context.Background(), authCodeKey(code), data, ttl).Err()
+}
+
+// ConsumeAuthCode reads and deletes the code in one step, so it can only be
+// redeemed once.
+func (r *oauthRepository) ConsumeAuthCode(code string) (*models.AuthorizationCode, error) {
+	data, err := r.redis.GetDel(context.Background(), authCodeKey(code)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var authCode models.AuthorizationCode
+	if err := json.Unmarshal(data, &authCode); err != nil {
+		return nil, err
+	}
+	return &authCode, nil
+}
+
+func (r *oauthRepository) SaveDeviceAuthorization(deviceCode string, auth *models.DeviceAuthorization, ttl time.Duration) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, userCodeKey(auth.UserCode), data, ttl)
+		pipe.Set(ctx, deviceCodeKey(deviceCode), auth.UserCode, ttl)
+		return nil
+	})
+	return err
+}
+
+func (r *oauthRepository) FindDeviceAuthorization(userCode string) (*models.DeviceAuthorization, error) {
+	data, err := r.redis.Get(context.Background(), userCodeKey(userCode)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var auth models.DeviceAuthorization
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func (r *oauthRepository) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	// XX: never resurrect an authorization that expired meanwhile
+	return r.redis.SetArgs(context.Background(), userCodeKey(auth.UserCode), data, redis.SetArgs{KeepTTL: true, Mode: "XX"}).Err()
+}
+
+func (r *oauthRepository) FetchDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	userCode, err := r.redis.Get(context.Background(), deviceCodeKey(deviceCode)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.FindDeviceAuthorization(userCode)
+}
+
+// ConsumeDeviceAuthorization deletes the authorization. Only the caller that
+// actually deleted it gets true, so tokens are issued once.
+func (r *oauthRepository) ConsumeDeviceAuthorization(deviceCode string) (bool, error) {
+	ctx := context.Background()
+
+	userCode, err := r.redis.GetDel(ctx, deviceCodeKey(deviceCode)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, r.redis.Del(ctx, userCodeKey(userCode)).Err()
+}
+
+// ThrottleDevicePoll returns false when the device polled again within the
+// interval.
+func (r *oauthRepository) ThrottleDevicePoll(deviceCode string, interval time.Duration) (bool, error) {
+	return r.redis.SetNX(context.Background(), deviceCodeKey(deviceCode)+":poll", 1, interval).Result()
+}
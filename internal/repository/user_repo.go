@@ -1,15 +1,27 @@
 package repository
 
 import (
+	"time"
+
 	"auth-service/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
 	CreateUser(user *models.User) error
 	FindByEmail(email string) (*models.User, error)
 	FindByID(id uint) (*models.User, error)
+	UpdatePhone(id uint, phone string, verifiedAt *time.Time) error
+	UpdatePassword(id uint, hash string, keepHistory int) error
+	ListPasswordHistory(id uint, limit int) ([]string, error)
+	RehashPassword(id uint, oldHash, newHash string) (bool, error)
+	MigrateLegacyPassword(id uint, legacyHash, newHash string) (bool, error)
+	CountLegacyPasswords() (map[string]int64, error)
+	CountPasswordPeppers() (map[string]int64, error)
+	CountHistoryPeppers() (map[string]int64, error)
+	UpsertImportedUsers(users []models.User) (int64, error)
 }
 
 type userRepository struct {
@@ -26,7 +38,7 @@ func (r *userRepository) CreateUser(user *models.User) error {
 
 func (r *userRepository) FindByEmail(email string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("email = ?", email).First(&user).Error
+	err := r.db.Where("lower(email) = ?", models.NormalizeEmail(email)).First(&user).Error
 	return &user, err
 }
 
@@ -35,3 +47,146 @@ func (r *userRepository) FindByID(id uint) (*models.User, error) {
 	err := r.db.First(&user, id).Error
 	return &user, err
 }
+
+func (r *userRepository) UpdatePhone(id uint, phone string, verifiedAt *time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"phone":             phone,
+		"phone_verified_at": verifiedAt,
+	}).Error
+}
+
+// UpdatePassword replaces the password hash and moves the previous one into
+// the history, which is pruned to the newest keepHistory entries.
+func (r *userRepository) UpdatePassword(id uint, hash string, keepHistory int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Select("id", "password").First(&user, id).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&user).Updates(map[string]interface{}{
+			"password":             hash,
+			"legacy_password_hash": "",
+		}).Error
+		if err != nil {
+			return err
+		}
+		if user.Password == "" {
+			// Nothing to remember for users still on a legacy hash
+			return nil
+		}
+		if keepHistory <= 0 {
+			return tx.Where("user_id = ?", id).Delete(&models.PasswordHistory{}).Error
+		}
+
+		if err := tx.Create(&models.PasswordHistory{UserID: id, Hash: user.Password}).Error; err != nil {
+			return err
+		}
+		newest := tx.Model(&models.PasswordHistory{}).Select("id").Where("user_id = ?", id).Order("id DESC").Limit(keepHistory)
+		return tx.Where("user_id = ? AND id NOT IN (?)", id, newest).Delete(&models.PasswordHistory{}).Error
+	})
+}
+
+// ListPasswordHistory returns the newest replaced password hashes first.
+func (r *userRepository) ListPasswordHistory(id uint, limit int) ([]string, error) {
+	var hashes []string
+	err := r.db.Model(&models.PasswordHistory{}).Where("user_id = ?", id).Order("id DESC").Limit(limit).Pluck("hash", &hashes).Error
+	return hashes, err
+}
+
+// RehashPassword swaps the hash of an unchanged password for a stronger one.
+// It does nothing when the password was changed in the meantime.
+func (r *userRepository) RehashPassword(id uint, oldHash, newHash string) (bool, error) {
+	result := r.db.Model(&models.User{}).Where("id = ? AND password = ?", id, oldHash).Update("password", newHash)
+	return result.RowsAffected == 1, result.Error
+}
+
+// MigrateLegacyPassword replaces a verified legacy hash by an Argon2id hash.
+// It does nothing when the legacy hash was replaced in the meantime.
+func (r *userRepository) MigrateLegacyPassword(id uint, legacyHash, newHash string) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND legacy_password_hash = ?", id, legacyHash).
+		Updates(map[string]interface{}{
+			"password":             newHash,
+			"legacy_password_hash": "",
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountLegacyPasswords counts the users still on a legacy hash by the
+// algorithm prefix of the hash. Unprefixed hashes count under "".
+func (r *userRepository) CountLegacyPasswords() (map[string]int64, error) {
+	var rows []struct {
+		Algorithm string
+		Count     int64
+	}
+	err := r.db.Model(&models.User{}).
+		Select("CASE WHEN position(':' in legacy_password_hash) > 0 THEN split_part(legacy_password_hash, ':', 1) ELSE '' END AS algorithm, count(*) AS count").
+		Where("legacy_password_hash <> ''").
+		Group("algorithm").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Algorithm] = row.Count
+	}
+	return counts, nil
+}
+
+// CountPasswordPeppers counts the users by the pepper version of their
+// password hash. Unpeppered hashes count under "".
+func (r *userRepository) CountPasswordPeppers() (map[string]int64, error) {
+	return countPepperVersions(r.db.Model(&models.User{}), "password")
+}
+
+// CountHistoryPeppers counts the password history entries by pepper
+// version. They are never rehashed, so an old version stays in use until the
+// entries are pruned.
+func (r *userRepository) CountHistoryPeppers() (map[string]int64, error) {
+	return countPepperVersions(r.db.Model(&models.PasswordHistory{}), "hash")
+}
+
+// countPepperVersions groups the Argon2id hashes in column by their keyid
+// parameter.
+func countPepperVersions(query *gorm.DB, column string) (map[string]int64, error) {
+	var rows []struct {
+		KeyID string
+		Count int64
+	}
+	err := query.
+		Select("COALESCE(substring(" + column + " from 'keyid=([0-9]+)'), '') AS key_id, count(*) AS count").
+		Where(column + " <> ''").
+		Group("key_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.KeyID] = row.Count
+	}
+	return counts, nil
+}
+
+// UpsertImportedUsers inserts users from the previous system, or refreshes
+// those imported before. Users who have set a password here, by migrating
+// their legacy hash or otherwise, are left alone. It returns how many rows
+// were written.
+func (r *userRepository) UpsertImportedUsers(users []models.User) (int64, error) {
+	if len(users) == 0 {
+		return 0, nil
+	}
+	result := r.db.Clauses(clause.OnConflict{
+		// Accounts registered before emails were normalized may have
+		// upper case letters
+		Columns:   []clause.Column{{Name: "(lower(email))", Raw: true}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "legacy_password_hash", "created_at", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "users.password = '' AND users.deleted_at IS NULL"},
+		}},
+	}).Create(&users)
+	return result.RowsAffected, result.Error
+}
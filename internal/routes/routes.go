@@ -2,18 +2,43 @@ package routes
 
 import (
 	"net/http"
+	"time"
 
-	"auth-service/internal/config"
 	"auth-service/internal/handlers"
 	"auth-service/internal/middleware"
 	"auth-service/internal/models"
+	"auth-service/internal/utils"
 	"auth-service/pkg/database"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 )
 
-func SetupRoutes(r *gin.Engine, authHandler *handlers.AuthHandler, cfg *config.Config, rdb *redis.Client) {
+func SetupRoutes(r *gin.Engine, authHandler *handlers.AuthHandler, oauthHandler *handlers.OAuthHandler, mfaHandler *handlers.MFAHandler, webAuthnHandler *handlers.WebAuthnHandler, passwordlessHandler *handlers.PasswordlessHandler, passwordHandler *handlers.PasswordHandler, wellKnownHandler *handlers.WellKnownHandler, keys *utils.KeySet, rdb *redis.Client) {
+	r.GET("/.well-known/jwks.json", wellKnownHandler.JWKS)
+	r.GET("/.well-known/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+
+	authMiddleware := middleware.AuthMiddleware(keys, rdb)
+	requireUser := middleware.RequireUser()
+	requireFirstParty := middleware.RequireFirstParty()
+	// Changes to how the user logs in need a recent login or /auth/reauth.
+	// Users without a second factor can only prove their password.
+	requireRecentAuth := middleware.RequireACR(utils.ACRBasic, 10*time.Minute)
+	requireRecentMFA := middleware.RequireACR(utils.ACRMFA, 10*time.Minute)
+
+	r.GET("/userinfo", authMiddleware, requireUser, oauthHandler.UserInfo)
+
+	oauth := r.Group("/oauth")
+	{
+		oauth.GET("/authorize", authMiddleware, requireFirstParty, oauthHandler.Authorize)
+		oauth.POST("/token", oauthHandler.Token)
+		oauth.POST("/device_authorization", oauthHandler.DeviceAuthorization)
+		oauth.GET("/device", authMiddleware, requireFirstParty, oauthHandler.DeviceInfo)
+		oauth.POST("/device", authMiddleware, requireFirstParty, oauthHandler.DeviceVerify)
+		oauth.POST("/introspect", oauthHandler.Introspect)
+		oauth.POST("/revoke", oauthHandler.Revoke)
+	}
+
 	api := r.Group("/api/v1")
 	{
 		auth := api.Group("/auth")
@@ -21,11 +46,50 @@ func SetupRoutes(r *gin.Engine, authHandler *handlers.AuthHandler, cfg *config.C
 			auth.POST("/register", authHandler.Register)
 			auth.POST("/login", authHandler.Login)
 			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/mfa/verify", mfaHandler.Verify)
+			auth.POST("/mfa/webauthn", webAuthnHandler.BeginMFA)
+			auth.POST("/mfa/sms", mfaHandler.SendSMSLoginCode)
+			auth.POST("/passkey/begin", webAuthnHandler.BeginLogin)
+			auth.POST("/passkey/finish", webAuthnHandler.FinishLogin)
+			auth.POST("/email/start", passwordlessHandler.StartEmail)
+			auth.POST("/email/verify", passwordlessHandler.VerifyEmailCode)
+			auth.POST("/email/link", passwordlessHandler.VerifyMagicLink)
+			auth.POST("/password/forgot", passwordHandler.Forgot)
+			auth.POST("/password/reset", passwordHandler.Reset)
+			auth.POST("/reauth", authMiddleware, requireFirstParty, authHandler.Reauthenticate)
+			auth.POST("/logout", authMiddleware, requireFirstParty, authHandler.Logout)
+			auth.POST("/logout-all", authMiddleware, requireFirstParty, authHandler.LogoutAll)
+		}
+
+		sessions := api.Group("/sessions")
+		sessions.Use(authMiddleware, requireFirstParty)
+		{
+			sessions.GET("", authHandler.ListSessions)
+			sessions.DELETE("/:id", authHandler.RevokeSession)
+		}
+
+		account := api.Group("/account")
+		account.Use(authMiddleware, requireFirstParty)
+		{
+			account.POST("/password", passwordHandler.Change)
+			account.POST("/mfa/totp", requireRecentAuth, mfaHandler.EnrollTOTP)
+			account.POST("/mfa/totp/confirm", requireRecentAuth, mfaHandler.ConfirmTOTP)
+			account.POST("/mfa/totp/disable", requireRecentMFA, mfaHandler.DisableTOTP)
+			account.POST("/mfa/sms", requireRecentAuth, mfaHandler.EnrollSMS)
+			account.POST("/mfa/sms/confirm", requireRecentAuth, mfaHandler.ConfirmSMS)
+			account.POST("/mfa/sms/code", mfaHandler.SendSMSAccountCode)
+			account.POST("/mfa/sms/disable", requireRecentMFA, mfaHandler.DisableSMS)
+			account.POST("/webauthn/register/begin", requireRecentAuth, webAuthnHandler.BeginRegistration)
+			account.POST("/webauthn/register/finish", requireRecentAuth, webAuthnHandler.FinishRegistration)
+			account.GET("/webauthn/credentials", webAuthnHandler.ListCredentials)
+			account.DELETE("/webauthn/credentials/:id", requireRecentMFA, webAuthnHandler.DeleteCredential)
+			account.GET("/devices", authHandler.ListTrustedDevices)
+			account.DELETE("/devices/:id", authHandler.RevokeTrustedDevice)
 		}
 
 		// Protected Route Example
 		protected := api.Group("/protected")
-		protected.Use(middleware.AuthMiddleware(cfg, rdb))
+		protected.Use(authMiddleware, requireFirstParty)
 		{
 			protected.GET("/profile", func(c *gin.Context) {
 				userId, _ := c.Get("user_id")
@@ -47,3 +111,9 @@ func SetupRoutes(r *gin.Engine, authHandler *handlers.AuthHandler, cfg *config.C
 		}
 	}
 }
+
+// SetupMetricsRoutes serves the metrics on the internal listener, which has
+// no authentication and must not be reachable from outside.
+func SetupMetricsRoutes(r *gin.Engine, authHandler *handlers.AuthHandler) {
+	r.GET("/metrics", authHandler.Metrics)
+}
@@ -2,67 +2,240 @@ package services
 
 import (
 	"errors"
+	"log"
+	"time"
 
 	"auth-service/internal/config"
+	"auth-service/internal/events"
 	"auth-service/internal/models"
 	"auth-service/internal/repository"
 	"auth-service/internal/utils"
 
-	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrRefreshTokenClient = oauthError("invalid_grant", "Refresh token was issued to another client")
+)
+
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+	MFAMethodWebAuthn     = "webauthn"
+	MFAMethodSMS          = "sms"
+
+	mfaChallengeTTL = 5 * time.Minute
+)
+
+// MFARequiredError is returned by Login when the password was correct but the
+// user has a second factor. The login completes by presenting Token and a
+// code to MFAService.Verify. Reauthenticate returns it the same way.
+type MFARequiredError struct {
+	Token     string
+	Methods   []string
+	ExpiresIn int64
+}
+
+func (e *MFARequiredError) Error() string {
+	return "multi-factor authentication required"
+}
+
+// ClientInfo describes the device a request was made from. DeviceToken is
+// the token of a trusted device, if the client has one.
+type ClientInfo struct {
+	IP          string
+	UserAgent   string
+	DeviceName  string
+	DeviceToken string
+}
+
 type AuthService struct {
-	userRepo repository.UserRepository
-	authRepo repository.AuthRepository
-	cfg      *config.Config
+	userRepo      repository.UserRepository
+	authRepo      repository.AuthRepository
+	mfaRepo       repository.MFARepository
+	challengeRepo repository.ChallengeRepository
+	policy        *PasswordPolicy
+	keys          *utils.TokenKeys
+	events        events.Publisher
+	cfg           *config.Config
 }
 
-func NewAuthService(userRepo repository.UserRepository, authRepo repository.AuthRepository, cfg *config.Config) *AuthService {
+func NewAuthService(userRepo repository.UserRepository, authRepo repository.AuthRepository, mfaRepo repository.MFARepository, challengeRepo repository.ChallengeRepository, policy *PasswordPolicy, keys *utils.TokenKeys, events events.Publisher, cfg *config.Config) *AuthService {
 	return &AuthService{
-		userRepo: userRepo,
-		authRepo: authRepo,
-		cfg:      cfg,
+		userRepo:      userRepo,
+		authRepo:      authRepo,
+		mfaRepo:       mfaRepo,
+		challengeRepo: challengeRepo,
+		policy:        policy,
+		keys:          keys,
+		events:        events,
+		cfg:           cfg,
 	}
 }
 
 func (s *AuthService) Register(name, email, password string) error {
+	email = models.NormalizeEmail(email)
 	existingUser, _ := s.userRepo.FindByEmail(email)
 	if existingUser != nil && existingUser.ID != 0 {
 		return errors.New("email already registered")
 	}
 
+	user := &models.User{
+		Name:  name,
+		Email: email,
+	}
+	if err := s.policy.Check(password, user); err != nil {
+		return err
+	}
+
 	hashed, err := utils.HashPassword(password)
 	if err != nil {
 		return err
 	}
+	user.Password = hashed
+	return s.userRepo.CreateUser(user)
+}
 
-	user := &models.User{
-		Name:     name,
-		Email:    email,
-		Password: hashed,
+func (s *AuthService) Login(email, password string, client ClientInfo) (*utils.TokenDetails, error) {
+	user, err := s.userRepo.FindByEmail(models.NormalizeEmail(email))
+	if err != nil {
+		return nil, ErrInvalidCredentials
 	}
 
-	return s.userRepo.CreateUser(user)
+	if !s.checkPassword(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	amr := []string{utils.AMRPassword}
+	if err := s.requireMFA(user, client, amr); err != nil {
+		return nil, err
+	}
+	return s.StartSession(utils.Grant{UserID: user.ID, AMR: amr}, client)
 }
 
-func (s *AuthService) Login(email, password string) (*utils.TokenDetails, error) {
-	user, err := s.userRepo.FindByEmail(email)
+// rehashPassword upgrades a verified password hash made with weaker Argon2
+// parameters than the current ones. A failure only postpones the upgrade to
+// the next login.
+func (s *AuthService) rehashPassword(user *models.User, password string) {
+	if !utils.NeedsRehash(user.Password) {
+		return
+	}
+	hashed, err := utils.HashPassword(password)
+	if err == nil {
+		_, err = s.userRepo.RehashPassword(user.ID, user.Password, hashed)
+	}
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
 	}
+	user.Password = hashed
+}
 
-	match, err := utils.VerifyPassword(password, user.Password)
-	if err != nil || !match {
-		return nil, errors.New("invalid credentials")
+// requireMFA starts an MFA challenge for a login whose first factor used the
+// amr methods, when the user has a confirmed second factor and the login does
+// not come from a trusted device.
+func (s *AuthService) requireMFA(user *models.User, client ClientInfo, amr []string) error {
+	if s.deviceTrusted(user.ID, client.DeviceToken) {
+		return nil
 	}
+	return s.challengeMFA(user, &models.MFAChallenge{
+		UserID:     user.ID,
+		AMR:        amr,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		DeviceName: client.DeviceName,
+	})
+}
 
-	td, err := utils.GenerateToken(user.ID, s.cfg)
+// challengeMFA saves the challenge when the user has a confirmed second
+// factor, and returns it as an *MFARequiredError.
+func (s *AuthService) challengeMFA(user *models.User, challenge *models.MFAChallenge) error {
+	methods, err := s.mfaMethods(user)
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	token, err := utils.GenerateSecret(32)
+	if err != nil {
+		return err
+	}
+	challenge.CreatedAt = time.Now()
+	if err := s.challengeRepo.SaveMFAChallenge(token, challenge, mfaChallengeTTL); err != nil {
+		return err
+	}
+
+	return &MFARequiredError{
+		Token:     token,
+		Methods:   methods,
+		ExpiresIn: int64(mfaChallengeTTL.Seconds()),
+	}
+}
+
+// mfaMethods lists the second factors the user can complete a login with.
+// Recovery codes are issued with TOTP and only offered alongside it. SMS is
+// the weakest factor and only offered to users without TOTP, while codes can
+// be sent at all.
+func (s *AuthService) mfaMethods(user *models.User) ([]string, error) {
+	var methods []string
+
+	totp, err := s.mfaRepo.FindTOTP(user.ID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	case err != nil:
+		return nil, err
+	case totp.Confirmed():
+		methods = append(methods, MFAMethodTOTP, MFAMethodRecoveryCode)
+	}
+	if len(methods) == 0 && user.PhoneVerifiedAt != nil && s.cfg.SMSEnabled() {
+		methods = append(methods, MFAMethodSMS)
+	}
+
+	passkeys, err := s.mfaRepo.ListWebAuthnCredentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) > 0 {
+		methods = append(methods, MFAMethodWebAuthn)
+	}
+	return methods, nil
+}
+
+// StartSession issues the first token pair of a new session. Every session is
+// its own refresh token family, so the grant's FamilyID is assigned here.
+func (s *AuthService) StartSession(grant utils.Grant, client ClientInfo) (*utils.TokenDetails, error) {
+	grant.FamilyID = utils.NewID()
+	if grant.AuthTime.IsZero() {
+		grant.AuthTime = time.Now()
+	}
+	td, err := utils.GenerateToken(grant, s.keys)
 	if err != nil {
 		return nil, err
 	}
 
 	// Save token metadata to Redis via AuthRepo
-	err = s.authRepo.CreateAuth(user.ID, td.AccessUuid, td.RefreshUuid, td.AtExpires, td.RtExpires)
+	err = s.authRepo.CreateAuth(grant.UserID, td.FamilyID, td.AccessUuid, td.RefreshUuid, td.AtExpires, td.RtExpires)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.authRepo.SaveSession(&models.Session{
+		ID:              td.FamilyID,
+		UserID:          grant.UserID,
+		ClientID:        grant.ClientID,
+		IP:              client.IP,
+		UserAgent:       client.UserAgent,
+		DeviceName:      client.DeviceName,
+		CreatedAt:       now,
+		LastRefreshedAt: now,
+		AMR:             grant.AMR,
+		AuthTime:        grant.AuthTime,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -70,18 +243,23 @@ func (s *AuthService) Login(email, password string) (*utils.TokenDetails, error)
 	return td, nil
 }
 
-func (s *AuthService) Refresh(refreshToken string) (*utils.TokenDetails, error) {
-	// Verify Token
-	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.cfg.RefreshSecret), nil
-	})
+// Refresh rotates the refresh token of a first-party session. Tokens issued
+// to OAuth clients are only rotated by the token endpoint, once the client
+// has authenticated.
+func (s *AuthService) Refresh(refreshToken string, client ClientInfo) (*utils.TokenDetails, error) {
+	return s.refresh(refreshToken, "", client)
+}
+
+// refresh rotates a refresh token issued to the OAuth client clientID, or
+// to no client when clientID is empty.
+func (s *AuthService) refresh(refreshToken, clientID string, client ClientInfo) (*utils.TokenDetails, error) {
+	// Verify Token against the refresh key ring
+	claims, err := s.keys.Refresh.Parse(refreshToken)
 	if err != nil {
 		return nil, err
 	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, errors.New("invalid refresh token")
+	if issuedTo, _ := claims["client_id"].(string); issuedTo != clientID {
+		return nil, ErrRefreshTokenClient
 	}
 
 	refreshUuid, ok := claims["refresh_uuid"].(string)
@@ -94,25 +272,188 @@ func (s *AuthService) Refresh(refreshToken string) (*utils.TokenDetails, error)
 	}
 	userId := uint(userIdFloat)
 
-	// Check if token exists in Redis
-	val, err := s.authRepo.FetchAuth(refreshUuid)
-	if err != nil || val == "" {
-		return nil, errors.New("token expired or revoked")
+	familyID, _ := claims["family_id"].(string)
+	newFamily := familyID == ""
+	if newFamily {
+		// Tokens issued before families existed start a new family
+		val, err := s.authRepo.FetchAuth(refreshUuid)
+		if err != nil || val == "" {
+			return nil, errors.New("token expired or revoked")
+		}
+		s.authRepo.DeleteAuth(refreshUuid)
+		familyID = utils.NewID()
 	}
 
-	// Delete old metadata (Rotation)
-	s.authRepo.DeleteAuth(refreshUuid)
+	grant := utils.Grant{UserID: userId, FamilyID: familyID}
+	grant.ClientID = clientID
+	grant.Scope, _ = claims["scope"].(string)
+	// The session keeps the latest authentication, which a re-authentication
+	// may have upgraded since this refresh token was issued
+	if session, err := s.authRepo.FetchSession(familyID); err == nil {
+		grant.AMR = session.AMR
+		grant.AuthTime = session.AuthTime
+	}
 
-	td, err := utils.GenerateToken(userId, s.cfg)
+	td, err := utils.GenerateToken(grant, s.keys)
 	if err != nil {
 		return nil, err
 	}
 
 	// Register new token pair
-	err = s.authRepo.CreateAuth(userId, td.AccessUuid, td.RefreshUuid, td.AtExpires, td.RtExpires)
+	if newFamily {
+		err = s.authRepo.CreateAuth(userId, td.FamilyID, td.AccessUuid, td.RefreshUuid, td.AtExpires, td.RtExpires)
+	} else {
+		err = s.rotate(userId, refreshUuid, td)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if err := s.authRepo.TouchSession(td.FamilyID, client.IP, client.UserAgent); err != nil {
+		return nil, err
+	}
+
+	return td, nil
+}
+
+// rotate consumes the refresh token refreshUuid of the family of td and
+// registers td in its place. It fails once the family was revoked, even by
+// a revocation racing with the refresh.
+func (s *AuthService) rotate(userId uint, refreshUuid string, td *utils.TokenDetails) error {
+	rotated, err := s.authRepo.RotateAuth(userId, td.FamilyID, refreshUuid, td.AccessUuid, td.RefreshUuid, td.AtExpires, td.RtExpires)
+	if err != nil {
+		return err
+	}
+	if rotated {
+		return nil
+	}
+
+	// A token that was already rotated is being replayed: either the
+	// client or an attacker holds a stolen copy, so kill the whole family
+	if reused, _ := s.authRepo.IsRotated(td.FamilyID, refreshUuid); reused {
+		if err := s.authRepo.RevokeFamily(td.FamilyID); err != nil {
+			return err
+		}
+		s.events.Publish(events.New(events.RefreshTokenReused, userId, map[string]interface{}{
+			"family_id":    td.FamilyID,
+			"refresh_uuid": refreshUuid,
+		}))
+	}
+	return errors.New("token expired or revoked")
+}
+
+// Logout revokes the token pair of the current session. Tokens issued before
+// families existed only have their access token revoked.
+func (s *AuthService) Logout(accessUuid, familyID string) error {
+	if familyID == "" {
+		return s.authRepo.DeleteAuth(accessUuid)
+	}
+	return s.authRepo.RevokeFamily(familyID)
+}
+
+// LogoutAll revokes every session of the user on all devices.
+func (s *AuthService) LogoutAll(userID uint) error {
+	return s.authRepo.RevokeUserFamilies(userID)
+}
+
+// LogoutOthers revokes every session of the user except the current one.
+func (s *AuthService) LogoutOthers(userID uint, currentFamilyID string) error {
+	sessions, err := s.authRepo.ListSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == currentFamilyID {
+			continue
+		}
+		if err := s.authRepo.RevokeFamily(session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessions returns the active sessions of the user, flagging the one the
+// request was made with.
+func (s *AuthService) ListSessions(userID uint, currentFamilyID string) ([]models.Session, error) {
+	sessions, err := s.authRepo.ListSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentFamilyID
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs out a single session of the user.
+func (s *AuthService) RevokeSession(userID uint, sessionID string) error {
+	session, err := s.authRepo.FetchSession(sessionID)
+	if err != nil || session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	return s.authRepo.RevokeFamily(sessionID)
+}
+
+// Reauthenticate proves the user is still present on the current session,
+// for operations that require a recent login. Users with a second factor get
+// an *MFARequiredError and finish through MFAService.Verify.
+func (s *AuthService) Reauthenticate(userID uint, familyID, password string, client ClientInfo) (*utils.TokenDetails, error) {
+	session, err := s.authRepo.FetchSession(familyID)
+	if err != nil || session.UserID != userID || session.ClientID != "" {
+		// Only first-party sessions can be re-authenticated
+		return nil, ErrSessionNotFound
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.checkPassword(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	amr := []string{utils.AMRPassword}
+	err = s.challengeMFA(user, &models.MFAChallenge{
+		UserID:     userID,
+		AMR:        amr,
+		FamilyID:   familyID,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		DeviceName: session.DeviceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.upgradeSession(userID, familyID, amr, client)
+}
+
+// upgradeSession records a re-authentication on the session and replaces its
+// token pair, so the new tokens carry the new amr, acr and auth_time.
+func (s *AuthService) upgradeSession(userID uint, familyID string, amr []string, client ClientInfo) (*utils.TokenDetails, error) {
+	session, err := s.authRepo.FetchSession(familyID)
+	if err != nil || session.UserID != userID {
+		return nil, ErrSessionNotFound
+	}
+
+	now := time.Now()
+	if err := s.authRepo.UpdateSessionAuth(familyID, amr, now); err != nil {
+		return nil, err
+	}
+	if err := s.authRepo.RetireFamilyTokens(familyID); err != nil {
+		return nil, err
+	}
+
+	td, err := utils.GenerateToken(utils.Grant{UserID: userID, FamilyID: familyID, AMR: amr, AuthTime: now}, s.keys)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authRepo.CreateAuth(userID, familyID, td.AccessUuid, td.RefreshUuid, td.AtExpires, td.RtExpires)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authRepo.TouchSession(familyID, client.IP, client.UserAgent); err != nil {
+		return nil, err
+	}
 	return td, nil
 }
@@ -1,10 +1,14 @@
 package services_test
 
 import (
+	"errors"
 	"log"
+	"strings"
 	"testing"
+	"time"
 
 	"auth-service/internal/config"
+	"auth-service/internal/events"
 	"auth-service/internal/models"
 	"auth-service/internal/repository/mocks"
 	"auth-service/internal/services"
@@ -12,6 +16,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 func TestRegister_Success(t *testing.T) {
@@ -23,7 +28,8 @@ func TestRegister_Success(t *testing.T) {
 		RefreshSecret: "refresh",
 	}
 
-	service := services.NewAuthService(mockUserRepo, mockAuthRepo, cfg)
+	keys, _ := utils.LoadTokenKeys(cfg)
+	service := services.NewAuthService(mockUserRepo, mockAuthRepo, new(mocks.MockMFARepository), new(mocks.MockChallengeRepository), services.NewPasswordPolicy(mockUserRepo, nil, cfg), keys, &events.MemoryPublisher{}, cfg)
 
 	// Expectations
 	email := "test@example.com"
@@ -44,6 +50,22 @@ func TestRegister_Success(t *testing.T) {
 	mockUserRepo.AssertExpectations(t)
 }
 
+func TestRegister_NormalizesEmail(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+	env.userRepo.On("FindByEmail", "jane.doe@example.com").Return(nil, nil)
+	env.userRepo.On("CreateUser", mock.MatchedBy(func(user *models.User) bool {
+		return user.Email == "jane.doe@example.com"
+	})).Return(nil)
+
+	// Execute
+	err := env.authService.Register("Jane", " Jane.Doe@Example.COM ", "password123")
+
+	// Assert: stored like imported users, so lookups ignore case
+	assert.NoError(t, err)
+	env.userRepo.AssertExpectations(t)
+}
+
 func TestLogin_Success(t *testing.T) {
 	// Setup
 	mockUserRepo := new(mocks.MockUserRepository)
@@ -53,7 +75,9 @@ func TestLogin_Success(t *testing.T) {
 		RefreshSecret: "refresh",
 	}
 
-	service := services.NewAuthService(mockUserRepo, mockAuthRepo, cfg)
+	mockMFARepo := new(mocks.MockMFARepository)
+	keys, _ := utils.LoadTokenKeys(cfg)
+	service := services.NewAuthService(mockUserRepo, mockAuthRepo, mockMFARepo, new(mocks.MockChallengeRepository), services.NewPasswordPolicy(mockUserRepo, nil, cfg), keys, &events.MemoryPublisher{}, cfg)
 
 	// Prepare data
 	email := "test@example.com"
@@ -69,13 +93,18 @@ func TestLogin_Success(t *testing.T) {
 
 	// Expectations
 	mockUserRepo.On("FindByEmail", email).Return(user, nil)
+	mockMFARepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	mockMFARepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
 
 	// Mock AuthRepo CreateAuth
 	// We use mock.Anything for UUIDs because they are random
-	mockAuthRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAuthRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAuthRepo.On("SaveSession", mock.MatchedBy(func(session *models.Session) bool {
+		return session.UserID == user.ID && session.IP == "10.0.0.1" && session.DeviceName == "Pixel 8"
+	})).Return(nil)
 
 	// Execute
-	token, err := service.Login(email, password)
+	token, err := service.Login(email, password, services.ClientInfo{IP: "10.0.0.1", UserAgent: "test", DeviceName: "Pixel 8"})
 
 	// Assert
 	assert.NoError(t, err)
@@ -86,13 +115,269 @@ func TestLogin_Success(t *testing.T) {
 	mockAuthRepo.AssertExpectations(t)
 }
 
+// expectPasswordLogin lets user log in with a password and no second factor.
+func (e *testEnv) expectPasswordLogin(user *models.User) {
+	e.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	e.expectSession(user)
+}
+
+func TestLogin_RehashesWeakerHash(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+
+	// A hash made before the parameters were raised
+	oldHash, _ := utils.HashPassword("password123")
+	assert.NoError(t, utils.SetArgonConfig(utils.ArgonConfig{Time: 2, Memory: 64 * 1024, Threads: 4, KeyLen: 32}))
+	t.Cleanup(func() {
+		utils.SetArgonConfig(utils.ArgonConfig{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32})
+	})
+
+	user := &models.User{ID: 1, Email: "test@example.com", Password: oldHash}
+	env.expectPasswordLogin(user)
+	var newHash string
+	env.userRepo.On("RehashPassword", user.ID, oldHash, mock.Anything).Run(func(args mock.Arguments) {
+		newHash = args.String(2)
+	}).Return(true, nil).Once()
+
+	// Execute
+	_, err := env.authService.Login(user.Email, "password123", services.ClientInfo{})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, utils.NeedsRehash(newHash))
+	match, _ := utils.VerifyPassword("password123", newHash)
+	assert.True(t, match)
+
+	// The upgraded hash is left alone on the next login
+	_, err = env.authService.Login(user.Email, "password123", services.ClientInfo{})
+	assert.NoError(t, err)
+	env.userRepo.AssertNumberOfCalls(t, "RehashPassword", 1)
+}
+
+func TestLogin_RotatesPepper(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+	t.Cleanup(func() { utils.SetPeppers(nil) })
+
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{{Version: 1, Secret: []byte(strings.Repeat("a", 32))}}))
+	oldHash, _ := utils.HashPassword("password123")
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{
+		{Version: 1, Secret: []byte(strings.Repeat("a", 32))},
+		{Version: 2, Secret: []byte(strings.Repeat("b", 32))},
+	}))
+
+	user := &models.User{ID: 1, Email: "test@example.com", Password: oldHash}
+	env.expectPasswordLogin(user)
+	var newHash string
+	env.userRepo.On("RehashPassword", user.ID, oldHash, mock.Anything).Run(func(args mock.Arguments) {
+		newHash = args.String(2)
+	}).Return(true, nil).Once()
+
+	// Execute
+	_, err := env.authService.Login(user.Email, "password123", services.ClientInfo{})
+
+	// Assert: the hash moves to the newest pepper
+	assert.NoError(t, err)
+	assert.Contains(t, newHash, ",keyid=2$")
+	assert.False(t, utils.NeedsRehash(newHash))
+}
+
+func TestLogin_AsymmetricSigning(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			// Setup
+			env := newTestEnv(t, &config.Config{JWTAlgorithm: alg})
+			hashedPassword, _ := utils.HashPassword("password123")
+			user := &models.User{ID: 1, Email: "test@example.com", Password: hashedPassword}
+			env.expectPasswordLogin(user)
+
+			// Execute
+			token, err := env.authService.Login(user.Email, "password123", services.ClientInfo{})
+			assert.NoError(t, err)
+
+			// Assert: verifiable with the key set and the kid is published in the JWKS
+			claims, err := env.keys.Access.Parse(token.AccessToken)
+			assert.NoError(t, err)
+			assert.Equal(t, float64(user.ID), claims["user_id"])
+
+			jwks := env.keys.Access.JWKS()
+			assert.Len(t, jwks.Keys, 1)
+			assert.Equal(t, alg, jwks.Keys[0].Alg)
+		})
+	}
+}
+
+func TestRefresh_AfterKeyRotation(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{JWTKeysDir: t.TempDir()})
+
+	// Token pair signed with the original keys
+	oldToken, err := utils.GenerateToken(utils.Grant{UserID: 1, FamilyID: "family-1"}, env.keys)
+	assert.NoError(t, err)
+	oldAccessKid := env.keys.Access.Active().ID
+
+	// Rotate both rings with immediate activation
+	for _, rotator := range utils.NewKeyRotators(env.cfg, env.keys) {
+		_, err := rotator.Rotate(true)
+		assert.NoError(t, err)
+	}
+	assert.NotEqual(t, oldAccessKid, env.keys.Access.Active().ID)
+
+	// Retired keys still verify tokens they signed
+	_, err = env.keys.Access.Parse(oldToken.AccessToken)
+	assert.NoError(t, err)
+
+	env.authRepo.On("RotateAuth", uint(1), "family-1", oldToken.RefreshUuid, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+	env.authRepo.On("TouchSession", "family-1", mock.Anything, mock.Anything).Return(nil)
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: 1, AMR: []string{"pwd", "otp", "mfa"}, AuthTime: authTime}, nil)
+
+	// Execute
+	token, err := env.authService.Refresh(oldToken.RefreshToken, services.ClientInfo{})
+
+	// Assert: the new pair is signed with the new active keys
+	assert.NoError(t, err)
+	assert.NotNil(t, token)
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	env.authRepo.AssertExpectations(t)
+
+	// and keeps the authentication of the session
+	assert.Equal(t, utils.ACRMFA, claims["acr"])
+	assert.Equal(t, float64(authTime.Unix()), claims["auth_time"])
+}
+
+func TestReauthenticate_UpgradesSession(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: 1}, nil)
+	env.authRepo.On("FetchSession", "family-2").Return(&models.Session{ID: "family-2", UserID: 2}, nil)
+
+	// Execute & Assert
+	_, err := env.authService.Reauthenticate(user.ID, "family-1", "wrong", services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrInvalidCredentials)
+	_, err = env.authService.Reauthenticate(user.ID, "family-2", "password123", services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrSessionNotFound)
+
+	env.authRepo.On("UpdateSessionAuth", "family-1", []string{utils.AMRPassword}, mock.Anything).Return(nil)
+	env.authRepo.On("RetireFamilyTokens", "family-1").Return(nil)
+	env.authRepo.On("CreateAuth", user.ID, "family-1", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("TouchSession", "family-1", mock.Anything, mock.Anything).Return(nil)
+
+	token, err := env.authService.Reauthenticate(user.ID, "family-1", "password123", services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "family-1", token.FamilyID)
+
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.ACRBasic, claims["acr"])
+	assert.Equal(t, []interface{}{"pwd"}, claims["amr"])
+	assert.InDelta(t, float64(time.Now().Unix()), claims["auth_time"], 5)
+	env.authRepo.AssertExpectations(t)
+}
+
+func TestRefresh_RejectsClientToken(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+
+	// A refresh token issued to a confidential OAuth client
+	leaked, err := utils.GenerateToken(utils.Grant{UserID: 1, FamilyID: "family-1", ClientID: "client-1", Scope: "openid"}, env.keys)
+	assert.NoError(t, err)
+
+	// Execute
+	_, err = env.authService.Refresh(leaked.RefreshToken, services.ClientInfo{})
+
+	// Assert
+	assert.ErrorIs(t, err, services.ErrRefreshTokenClient)
+	env.authRepo.AssertNotCalled(t, "RotateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRefresh_ReuseRevokesFamily(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+
+	// A refresh token that the legitimate client already exchanged
+	stolen, err := utils.GenerateToken(utils.Grant{UserID: 1, FamilyID: "family-1"}, env.keys)
+	assert.NoError(t, err)
+
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: 1}, nil)
+	env.authRepo.On("RotateAuth", uint(1), "family-1", stolen.RefreshUuid, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(false, nil)
+	env.authRepo.On("IsRotated", "family-1", stolen.RefreshUuid).Return(true, nil)
+	env.authRepo.On("RevokeFamily", "family-1").Return(nil)
+
+	// Execute
+	token, err := env.authService.Refresh(stolen.RefreshToken, services.ClientInfo{})
+
+	// Assert
+	assert.Error(t, err)
+	assert.Nil(t, token)
+	assert.Equal(t, []string{events.RefreshTokenReused}, env.events.Types())
+	env.authRepo.AssertExpectations(t)
+}
+
+func TestRefresh_Revoked(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+
+	revoked, err := utils.GenerateToken(utils.Grant{UserID: 1, FamilyID: "family-1"}, env.keys)
+	assert.NoError(t, err)
+
+	// The family is gone, or was revoked while the refresh was running
+	env.authRepo.On("FetchSession", "family-1").Return(nil, errors.New("session not found"))
+	env.authRepo.On("RotateAuth", uint(1), "family-1", revoked.RefreshUuid, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(false, nil)
+	env.authRepo.On("IsRotated", "family-1", revoked.RefreshUuid).Return(false, nil)
+
+	// Execute
+	token, err := env.authService.Refresh(revoked.RefreshToken, services.ClientInfo{})
+
+	// Assert: no family revocation or security event for plain expiry
+	assert.Error(t, err)
+	assert.Nil(t, token)
+	assert.Empty(t, env.events.Events)
+	env.authRepo.AssertNotCalled(t, "RevokeFamily", mock.Anything)
+	// nor a new token pair for the revoked session
+	env.authRepo.AssertNotCalled(t, "CreateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	env.authRepo.AssertNotCalled(t, "TouchSession", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLogout(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+	env.authRepo.On("RevokeFamily", "family-1").Return(nil)
+	env.authRepo.On("RevokeUserFamilies", uint(1)).Return(nil)
+
+	// Execute & Assert
+	assert.NoError(t, env.authService.Logout("access-1", "family-1"))
+	assert.NoError(t, env.authService.LogoutAll(1))
+	env.authRepo.AssertExpectations(t)
+}
+
+func TestRevokeSession_OtherUser(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+	env.authRepo.On("FetchSession", "family-2").Return(&models.Session{ID: "family-2", UserID: 2}, nil)
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: 1}, nil)
+	env.authRepo.On("RevokeFamily", "family-1").Return(nil)
+
+	// Execute & Assert: users can only revoke their own sessions
+	assert.ErrorIs(t, env.authService.RevokeSession(1, "family-2"), services.ErrSessionNotFound)
+	assert.NoError(t, env.authService.RevokeSession(1, "family-1"))
+	env.authRepo.AssertNotCalled(t, "RevokeFamily", "family-2")
+}
+
 func TestLogin_InvalidPassword(t *testing.T) {
 	// Setup
 	mockUserRepo := new(mocks.MockUserRepository)
 	mockAuthRepo := new(mocks.MockAuthRepository)
 	cfg := &config.Config{}
 
-	service := services.NewAuthService(mockUserRepo, mockAuthRepo, cfg)
+	keys, _ := utils.LoadTokenKeys(cfg)
+	service := services.NewAuthService(mockUserRepo, mockAuthRepo, new(mocks.MockMFARepository), new(mocks.MockChallengeRepository), services.NewPasswordPolicy(mockUserRepo, nil, cfg), keys, &events.MemoryPublisher{}, cfg)
 
 	// Data
 	email := "test@example.com"
@@ -110,7 +395,7 @@ func TestLogin_InvalidPassword(t *testing.T) {
 	mockUserRepo.On("FindByEmail", email).Return(user, nil)
 
 	// Execute
-	token, err := service.Login(email, wrongPassword)
+	token, err := service.Login(email, wrongPassword, services.ClientInfo{})
 
 	// Assert
 	assert.Error(t, err)
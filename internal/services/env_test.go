@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"testing"
+
+	"auth-service/internal/config"
+	"auth-service/internal/events"
+	"auth-service/internal/mailer"
+	"auth-service/internal/repository/mocks"
+	"auth-service/internal/services"
+	"auth-service/internal/sms"
+	"auth-service/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEnv holds the mocked dependencies the services are built from. Tests
+// set expectations on the mocks and build the service under test with one
+// of the methods below.
+type testEnv struct {
+	cfg           *config.Config
+	keys          *utils.TokenKeys
+	events        *events.MemoryPublisher
+	userRepo      *mocks.MockUserRepository
+	authRepo      *mocks.MockAuthRepository
+	mfaRepo       *mocks.MockMFARepository
+	challengeRepo *mocks.MockChallengeRepository
+	clientRepo    *mocks.MockClientRepository
+	oauthRepo     *mocks.MockOAuthRepository
+	mailer        *mailer.MemoryMailer
+	sms           *sms.FakeSender
+	authService   *services.AuthService
+}
+
+// newTestEnv creates mocks and an AuthService for cfg. The token secrets
+// default to test values.
+func newTestEnv(t *testing.T, cfg *config.Config) *testEnv {
+	if cfg.JWTSecret == "" {
+		cfg.JWTSecret = "secret"
+	}
+	if cfg.RefreshSecret == "" {
+		cfg.RefreshSecret = "refresh"
+	}
+	keys, err := utils.LoadTokenKeys(cfg)
+	assert.NoError(t, err)
+
+	env := &testEnv{
+		cfg:           cfg,
+		keys:          keys,
+		events:        &events.MemoryPublisher{},
+		userRepo:      new(mocks.MockUserRepository),
+		authRepo:      new(mocks.MockAuthRepository),
+		mfaRepo:       new(mocks.MockMFARepository),
+		challengeRepo: new(mocks.MockChallengeRepository),
+		clientRepo:    new(mocks.MockClientRepository),
+		oauthRepo:     new(mocks.MockOAuthRepository),
+		mailer:        &mailer.MemoryMailer{},
+		sms:           &sms.FakeSender{},
+	}
+	policy := services.NewPasswordPolicy(env.userRepo, nil, cfg)
+	env.authService = services.NewAuthService(env.userRepo, env.authRepo, env.mfaRepo, env.challengeRepo, policy, keys, env.events, cfg)
+	return env
+}
+
+func (e *testEnv) oauthService() *services.OAuthService {
+	return services.NewOAuthService(e.clientRepo, e.oauthRepo, e.authRepo, e.userRepo, e.authService, e.keys, e.cfg)
+}
+
+func (e *testEnv) webAuthnService(t *testing.T) *services.WebAuthnService {
+	service, err := services.NewWebAuthnService(e.userRepo, e.mfaRepo, e.challengeRepo, e.authService, e.cfg)
+	assert.NoError(t, err)
+	return service
+}
+
+func (e *testEnv) mfaService(t *testing.T) *services.MFAService {
+	return services.NewMFAService(e.userRepo, e.mfaRepo, e.challengeRepo, e.authService, e.webAuthnService(t), e.sms, e.cfg)
+}
+
+func (e *testEnv) passwordlessService() *services.PasswordlessService {
+	return services.NewPasswordlessService(e.userRepo, e.challengeRepo, e.authService, e.mailer, e.cfg)
+}
+
+func (e *testEnv) passwordService() *services.PasswordService {
+	return services.NewPasswordService(e.userRepo, e.challengeRepo, e.authService, e.mailer, e.events, e.cfg)
+}
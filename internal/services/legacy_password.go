@@ -0,0 +1,66 @@
+package services
+
+import (
+	"log"
+
+	"auth-service/internal/legacy"
+	"auth-service/internal/metrics"
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+)
+
+// checkPassword verifies the password of user against its Argon2id hash or,
+// for users imported from the previous system, against its legacy hash.
+// A verified password is rehashed when its hash is outdated.
+func (s *AuthService) checkPassword(user *models.User, password string) bool {
+	if user.Password != "" {
+		match, err := utils.VerifyPassword(password, user.Password)
+		if err != nil || !match {
+			return false
+		}
+		s.rehashPassword(user, password)
+		return true
+	}
+	if user.LegacyPasswordHash == "" {
+		return false
+	}
+
+	match, err := legacy.Verify(password, user.LegacyPasswordHash)
+	if err != nil {
+		log.Printf("Failed to verify legacy password of user %d: %v", user.ID, err)
+		return false
+	}
+	if !match {
+		return false
+	}
+	s.migrateLegacyPassword(user, password)
+	return true
+}
+
+// migrateLegacyPassword replaces a verified legacy hash by an Argon2id hash.
+// A failure leaves the legacy hash in place for the next login.
+func (s *AuthService) migrateLegacyPassword(user *models.User, password string) {
+	algorithm, _ := legacy.Split(user.LegacyPasswordHash)
+
+	var migrated bool
+	hashed, err := utils.HashPassword(password)
+	if err == nil {
+		migrated, err = s.userRepo.MigrateLegacyPassword(user.ID, user.LegacyPasswordHash, hashed)
+	}
+	if err != nil {
+		log.Printf("Failed to migrate legacy password of user %d: %v", user.ID, err)
+		return
+	}
+	if !migrated {
+		// Migrated by a concurrent login or replaced by a reset
+		return
+	}
+	metrics.LegacyPasswordMigrations.Inc(algorithm)
+	user.Password = hashed
+	user.LegacyPasswordHash = ""
+}
+
+// LegacyPasswordCounts counts the users still on a legacy hash by algorithm.
+func (s *AuthService) LegacyPasswordCounts() (map[string]int64, error) {
+	return s.userRepo.CountLegacyPasswords()
+}
@@ -0,0 +1,163 @@
+package services_test
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"auth-service/internal/config"
+	"auth-service/internal/events"
+	"auth-service/internal/importer"
+	"auth-service/internal/legacy"
+	"auth-service/internal/metrics"
+	"auth-service/internal/models"
+	"auth-service/internal/repository/mocks"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func legacyHashes(t *testing.T, password string) map[string]string {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.NoError(t, err)
+	key, err := pbkdf2.Key(sha256.New, password, []byte("pepper"), 1000, 32)
+	assert.NoError(t, err)
+	salted := sha1.Sum([]byte("s4lt" + password))
+
+	return map[string]string{
+		"md5":           "482c811da5d5b4bc6d497ffa98491e38",
+		"md5 prefixed":  "md5:482c811da5d5b4bc6d497ffa98491e38",
+		"sha1 salted":   "sha1:s4lt$" + hex.EncodeToString(salted[:]),
+		"bcrypt":        string(bcryptHash),
+		"pbkdf2_sha256": "pbkdf2_sha256$1000$pepper$" + base64.StdEncoding.EncodeToString(key),
+	}
+}
+
+func TestLegacySplit(t *testing.T) {
+	tests := map[string]string{
+		"482c811da5d5b4bc6d497ffa98491e38":                             legacy.MD5,
+		"cbfdac6008f9cab4083784cbd1874f76618d2a97":                     legacy.SHA1,
+		"sha1:s4lt$cbfdac6008f9cab4083784cbd1874f76618d2a97":           legacy.SHA1,
+		"$2y$10$abcdefghijklmnopqrstuu5s2v8.iXieOjg/.AySBTTZIIVFJeBui": legacy.Bcrypt,
+		"pbkdf2_sha256$1000$salt$a2V5":                                 legacy.PBKDF2SHA256,
+		"crypt:abc":                                                    "crypt",
+		"not a hash":                                                   "",
+	}
+	for stored, algorithm := range tests {
+		got, _ := legacy.Split(stored)
+		assert.Equal(t, algorithm, got, stored)
+	}
+
+	_, err := legacy.Verify("password123", "crypt:abc")
+	assert.ErrorIs(t, err, legacy.ErrUnknownAlgorithm)
+}
+
+func TestLogin_MigratesLegacyHash(t *testing.T) {
+	for name, stored := range legacyHashes(t, "password123") {
+		t.Run(name, func(t *testing.T) {
+			mockUserRepo := new(mocks.MockUserRepository)
+			mockAuthRepo := new(mocks.MockAuthRepository)
+			mockMFARepo := new(mocks.MockMFARepository)
+			cfg := &config.Config{JWTSecret: "secret", RefreshSecret: "refresh"}
+			keys, _ := utils.LoadTokenKeys(cfg)
+			service := services.NewAuthService(mockUserRepo, mockAuthRepo, mockMFARepo, new(mocks.MockChallengeRepository), services.NewPasswordPolicy(mockUserRepo, nil, cfg), keys, &events.MemoryPublisher{}, cfg)
+
+			user := &models.User{ID: 1, Email: "test@example.com", LegacyPasswordHash: stored}
+			mockUserRepo.On("FindByEmail", user.Email).Return(user, nil)
+			mockMFARepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+			mockMFARepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+			mockAuthRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			mockAuthRepo.On("SaveSession", mock.Anything).Return(nil)
+
+			var newHash string
+			mockUserRepo.On("MigrateLegacyPassword", user.ID, stored, mock.Anything).Run(func(args mock.Arguments) {
+				newHash = args.String(2)
+			}).Return(true, nil).Once()
+
+			_, err := service.Login(user.Email, "wrong password", services.ClientInfo{})
+			assert.ErrorIs(t, err, services.ErrInvalidCredentials)
+			mockUserRepo.AssertNotCalled(t, "MigrateLegacyPassword", mock.Anything, mock.Anything, mock.Anything)
+
+			_, err = service.Login(user.Email, "password123", services.ClientInfo{})
+			assert.NoError(t, err)
+			match, _ := utils.VerifyPassword("password123", newHash)
+			assert.True(t, match)
+
+			// The next login uses the Argon2id hash
+			assert.Empty(t, user.LegacyPasswordHash)
+			_, err = service.Login(user.Email, "password123", services.ClientInfo{})
+			assert.NoError(t, err)
+			mockUserRepo.AssertNumberOfCalls(t, "MigrateLegacyPassword", 1)
+		})
+	}
+}
+
+func TestLogin_LegacyHashMigratedConcurrently(t *testing.T) {
+	// Setup
+	mockUserRepo := new(mocks.MockUserRepository)
+	mockAuthRepo := new(mocks.MockAuthRepository)
+	mockMFARepo := new(mocks.MockMFARepository)
+	cfg := &config.Config{JWTSecret: "secret", RefreshSecret: "refresh"}
+	keys, _ := utils.LoadTokenKeys(cfg)
+	service := services.NewAuthService(mockUserRepo, mockAuthRepo, mockMFARepo, new(mocks.MockChallengeRepository), services.NewPasswordPolicy(mockUserRepo, nil, cfg), keys, &events.MemoryPublisher{}, cfg)
+
+	stored := legacyHashes(t, "password123")["sha1 salted"]
+	user := &models.User{ID: 1, Email: "test@example.com", LegacyPasswordHash: stored}
+	mockUserRepo.On("FindByEmail", user.Email).Return(user, nil)
+	mockMFARepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	mockMFARepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	mockAuthRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAuthRepo.On("SaveSession", mock.Anything).Return(nil)
+	mockUserRepo.On("MigrateLegacyPassword", user.ID, stored, mock.Anything).Return(false, nil)
+	before := metrics.LegacyPasswordMigrations.Snapshot()[legacy.SHA1]
+
+	// Execute
+	_, err := service.Login(user.Email, "password123", services.ClientInfo{})
+
+	// Assert: the login succeeds but the other request's migration counts
+	assert.NoError(t, err)
+	assert.Equal(t, stored, user.LegacyPasswordHash)
+	assert.Empty(t, user.Password)
+	assert.Equal(t, before, metrics.LegacyPasswordMigrations.Snapshot()[legacy.SHA1])
+}
+
+func TestLogin_ImportedUserWithMixedCaseEmail(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{})
+	service := env.authService
+
+	var imported []models.User
+	env.userRepo.On("UpsertImportedUsers", mock.Anything).Run(func(args mock.Arguments) {
+		imported = args.Get(0).([]models.User)
+	}).Return(int64(1), nil)
+	reader, err := importer.NewCSVReader(strings.NewReader("email,name,password_hash\nJohn.Doe@Example.com,John,482c811da5d5b4bc6d497ffa98491e38\n"))
+	assert.NoError(t, err)
+	imp := importer.New(env.userRepo, 100, io.Discard, func(*importer.Checkpoint) error { return nil })
+	assert.NoError(t, imp.Run(reader, &importer.Checkpoint{}))
+	assert.Len(t, imported, 1)
+	user := &imported[0]
+	user.ID = 1
+
+	env.userRepo.On("FindByEmail", "john.doe@example.com").Return(user, nil)
+	env.userRepo.On("MigrateLegacyPassword", user.ID, user.LegacyPasswordHash, mock.Anything).Return(true, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.authRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	// Execute
+	_, err = service.Login("  John.Doe@EXAMPLE.com", "password123", services.ClientInfo{})
+
+	// Assert: the address matches whatever its case
+	assert.NoError(t, err)
+	env.userRepo.AssertCalled(t, "MigrateLegacyPassword", user.ID, mock.Anything, mock.Anything)
+}
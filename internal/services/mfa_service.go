@@ -0,0 +1,328 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/sms"
+	"auth-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	maxMFAAttempts    = 5
+
+	// Failures count across challenges, so starting a new login does not
+	// reset them. After maxMFAFailures within mfaFailureWindow the user is
+	// locked out until the window ends.
+	maxMFAFailures   = 10
+	mfaFailureWindow = 15 * time.Minute
+)
+
+var (
+	ErrMFAAlreadyEnrolled = errors.New("multi-factor authentication already enabled")
+	ErrMFANotEnrolled     = errors.New("multi-factor authentication not enabled")
+	ErrInvalidMFACode     = errors.New("invalid verification code")
+	ErrMFAChallenge       = errors.New("MFA challenge expired or invalid")
+	ErrMFALocked          = errors.New("too many failed verification attempts, please try again later")
+)
+
+// TOTPEnrollment is shown once to the user so they can add the secret to an
+// authenticator app.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type MFAService struct {
+	userRepo      repository.UserRepository
+	mfaRepo       repository.MFARepository
+	challengeRepo repository.ChallengeRepository
+	authService   *AuthService
+	passkeys      *WebAuthnService
+	sms           sms.Sender
+	box           *utils.SecretBox
+	cfg           *config.Config
+}
+
+func NewMFAService(userRepo repository.UserRepository, mfaRepo repository.MFARepository, challengeRepo repository.ChallengeRepository, authService *AuthService, passkeys *WebAuthnService, sms sms.Sender, cfg *config.Config) *MFAService {
+	return &MFAService{
+		userRepo:      userRepo,
+		mfaRepo:       mfaRepo,
+		challengeRepo: challengeRepo,
+		authService:   authService,
+		passkeys:      passkeys,
+		sms:           sms,
+		box:           utils.NewSecretBox(cfg.MFAEncryptionKey),
+		cfg:           cfg,
+	}
+}
+
+// EnrollTOTP generates a new secret for the user. Logins are not affected
+// until ConfirmTOTP proves the authenticator app works; enrolling again before
+// that replaces the secret.
+func (s *MFAService) EnrollTOTP(userID uint) (*TOTPEnrollment, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := s.mfaRepo.FindTOTP(userID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		cred = &models.TOTPCredential{UserID: userID}
+	case err != nil:
+		return nil, err
+	case cred.Confirmed():
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if cred.EncryptedSecret, err = s.box.Seal([]byte(secret)); err != nil {
+		return nil, err
+	}
+	cred.LastUsedStep = 0
+	if err := s.mfaRepo.SaveTOTP(cred); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(s.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP with a first valid code and returns the recovery
+// codes, which are never shown again.
+func (s *MFAService) ConfirmTOTP(userID uint, code string) ([]string, error) {
+	cred, err := s.mfaRepo.FindTOTP(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cred.Confirmed() {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	if err := s.checkTOTP(cred, code); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cred.ConfirmedAt = &now
+	if err := s.mfaRepo.SaveTOTP(cred); err != nil {
+		return nil, err
+	}
+	return s.regenerateRecoveryCodes(userID)
+}
+
+// DisableTOTP removes the second factor and its recovery codes. It requires a
+// current code so a stolen access token alone cannot turn MFA off.
+func (s *MFAService) DisableTOTP(userID uint, code string) error {
+	cred, err := s.mfaRepo.FindTOTP(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !cred.Confirmed()) {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkTOTP(cred, code); err != nil {
+		return err
+	}
+	if err := s.mfaRepo.DeleteTOTP(userID); err != nil {
+		return err
+	}
+	return s.mfaRepo.ReplaceRecoveryCodes(userID, nil)
+}
+
+// Verify completes a login started by AuthService.Login with a TOTP code, a
+// recovery code, an SMS code or a WebAuthn assertion. The challenge is dropped
+// after too many attempts, and the user is locked out for a while after too
+// many failures across challenges. With rememberDevice, the tokens include a
+// DeviceToken that lets later logins from the device skip the second factor.
+func (s *MFAService) Verify(challengeToken, method, code string, rememberDevice bool) (*utils.TokenDetails, error) {
+	challenge, err := s.challengeRepo.FetchMFAChallenge(challengeToken)
+	if err != nil {
+		return nil, ErrMFAChallenge
+	}
+
+	attempts, err := s.challengeRepo.RecordMFAAttempt(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if attempts == 0 {
+		// Expired since it was fetched
+		return nil, ErrMFAChallenge
+	}
+	if attempts > maxMFAAttempts {
+		s.challengeRepo.DeleteMFAChallenge(challengeToken)
+		return nil, ErrMFAChallenge
+	}
+	failures, err := s.challengeRepo.CountMFAFailures(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if failures >= maxMFAFailures {
+		return nil, ErrMFALocked
+	}
+
+	factor, err := s.verifyFactor(challengeToken, challenge.UserID, method, code)
+	if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrWebAuthnFailed) {
+		if _, err := s.challengeRepo.RecordMFAFailure(challenge.UserID, mfaFailureWindow); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.challengeRepo.ResetMFAFailures(challenge.UserID); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.challengeRepo.DeleteMFAChallenge(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		// Completed concurrently
+		return nil, ErrMFAChallenge
+	}
+
+	amr := append(challenge.AMR, factor, utils.AMRMFA)
+	client := ClientInfo{
+		IP:         challenge.IP,
+		UserAgent:  challenge.UserAgent,
+		DeviceName: challenge.DeviceName,
+	}
+	var td *utils.TokenDetails
+	if challenge.FamilyID != "" {
+		td, err = s.authService.upgradeSession(challenge.UserID, challenge.FamilyID, amr, client)
+	} else {
+		td, err = s.authService.StartSession(utils.Grant{UserID: challenge.UserID, AMR: amr}, client)
+	}
+	if err != nil || !rememberDevice || s.cfg.TrustedDeviceTTL <= 0 {
+		return td, err
+	}
+
+	td.DeviceToken, err = s.authService.trustDevice(challenge.UserID, client)
+	if err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// verifyFactor checks the answer to a challenge of the user and returns the
+// amr value of the factor.
+func (s *MFAService) verifyFactor(challengeToken string, userID uint, method, code string) (string, error) {
+	switch method {
+	case MFAMethodTOTP:
+		cred, err := s.mfaRepo.FindTOTP(userID)
+		if err != nil || !cred.Confirmed() {
+			return "", ErrMFANotEnrolled
+		}
+		if err := s.checkTOTP(cred, code); err != nil {
+			return "", err
+		}
+		return utils.AMROTP, nil
+	case MFAMethodRecoveryCode:
+		if code == "" {
+			return "", ErrInvalidMFACode
+		}
+		used, err := s.mfaRepo.UseRecoveryCode(userID, hashRecoveryCode(code))
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return "", ErrInvalidMFACode
+		}
+		return utils.AMROTP, nil
+	case MFAMethodSMS:
+		if _, err := consumeOTP(s.challengeRepo, smsLoginDestination(challengeToken), otpCode, code); err != nil {
+			return "", ErrInvalidMFACode
+		}
+		return utils.AMRSMS, nil
+	case MFAMethodWebAuthn:
+		// The code is the assertion answering WebAuthnService.BeginMFA
+		if err := s.passkeys.verifyMFA(challengeToken, userID, []byte(code)); err != nil {
+			return "", err
+		}
+		return utils.AMRHardwareKey, nil
+	default:
+		return "", ErrInvalidMFACode
+	}
+}
+
+// checkTOTP validates a code and records its time step so it cannot be
+// replayed.
+func (s *MFAService) checkTOTP(cred *models.TOTPCredential, code string) error {
+	secret, err := s.box.Open(cred.EncryptedSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := utils.ValidateTOTP(string(secret), strings.TrimSpace(code), time.Now())
+	if !ok || step <= cred.LastUsedStep {
+		return ErrInvalidMFACode
+	}
+	used, err := s.mfaRepo.UseTOTPStep(cred.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	cred.LastUsedStep = step
+	return nil
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// regenerateRecoveryCodes creates codes of 80 random bits formatted as
+// xxxx-xxxx-xxxx-xxxx. That is enough entropy for a plain SHA-256 hash.
+func (s *MFAService) regenerateRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+		codes[i] = encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case and separators, which users often get wrong
+// when typing a code back.
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,496 @@
+package services_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// mfaConfig enables TOTP, SMS and passkeys for the MFA tests.
+func mfaConfig() *config.Config {
+	return &config.Config{
+		MFAEncryptionKey: "mfa-key",
+		MFAIssuer:        "Example",
+		TrustedDeviceTTL: 30 * 24 * time.Hour,
+		WebAuthnRPID:     "localhost",
+		WebAuthnRPName:   "Example",
+		WebAuthnOrigins:  "http://localhost:8888",
+		// Codes go to env.sms, the gateway is never called
+		SMSWebhookURL: "https://sms.example.com/send",
+	}
+}
+
+// confirmedTOTP returns an enrolled credential and its plain secret.
+func (e *testEnv) confirmedTOTP(t *testing.T, userID uint) (*models.TOTPCredential, string) {
+	secret, err := utils.GenerateTOTPSecret()
+	assert.NoError(t, err)
+	sealed, err := utils.NewSecretBox(e.cfg.MFAEncryptionKey).Seal([]byte(secret))
+	assert.NoError(t, err)
+
+	confirmedAt := time.Now()
+	return &models.TOTPCredential{UserID: userID, EncryptedSecret: sealed, ConfirmedAt: &confirmedAt}, secret
+}
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 secret "12345678901234567890", last 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := utils.TOTPCode(secret, time.Unix(unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+
+	// One step of clock drift is accepted, two are not
+	now := time.Unix(1234567890, 0)
+	_, ok := utils.ValidateTOTP(secret, "005924", now.Add(utils.TOTPPeriod))
+	assert.True(t, ok)
+	_, ok = utils.ValidateTOTP(secret, "005924", now.Add(2*utils.TOTPPeriod))
+	assert.False(t, ok)
+}
+
+func TestEnrollAndConfirmTOTP(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+	env.userRepo.On("FindByID", uint(1)).Return(&models.User{ID: 1, Email: "john@example.com"}, nil)
+	env.mfaRepo.On("FindTOTP", uint(1)).Return(nil, gorm.ErrRecordNotFound).Once()
+
+	var saved *models.TOTPCredential
+	env.mfaRepo.On("SaveTOTP", mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(0).(*models.TOTPCredential)
+	}).Return(nil)
+
+	// Execute & Assert
+	enrollment, err := service.EnrollTOTP(1)
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/Example:john@example.com?")
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	// Encrypted at rest, and not active yet
+	assert.NotContains(t, saved.EncryptedSecret, enrollment.Secret)
+	assert.False(t, saved.Confirmed())
+	plain, err := utils.NewSecretBox(env.cfg.MFAEncryptionKey).Open(saved.EncryptedSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, enrollment.Secret, string(plain))
+
+	env.mfaRepo.On("FindTOTP", uint(1)).Return(saved, nil)
+	env.mfaRepo.On("UseTOTPStep", uint(1), mock.Anything).Return(true, nil)
+	var hashes []string
+	env.mfaRepo.On("ReplaceRecoveryCodes", uint(1), mock.Anything).Run(func(args mock.Arguments) {
+		hashes = args.Get(1).([]string)
+	}).Return(nil)
+
+	_, err = service.ConfirmTOTP(1, "000000")
+	assert.ErrorIs(t, err, services.ErrInvalidMFACode)
+
+	code, _ := utils.TOTPCode(enrollment.Secret, time.Now())
+	recoveryCodes, err := service.ConfirmTOTP(1, code)
+	assert.NoError(t, err)
+	assert.True(t, saved.Confirmed())
+	assert.Len(t, recoveryCodes, 10)
+	assert.Len(t, hashes, 10)
+	assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, recoveryCodes[0])
+	assert.NotContains(t, hashes, recoveryCodes[0])
+
+	// Enrolling again once confirmed is refused
+	_, err = service.EnrollTOTP(1)
+	assert.ErrorIs(t, err, services.ErrMFAAlreadyEnrolled)
+}
+
+func TestLogin_MFARequired(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+	cred, _ := env.confirmedTOTP(t, user.ID)
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(cred, nil)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.MatchedBy(func(c *models.MFAChallenge) bool {
+		return c.UserID == user.ID && c.DeviceName == "Pixel 8"
+	}), mock.Anything).Return(nil)
+
+	// Execute
+	token, err := env.authService.Login(user.Email, "password123", services.ClientInfo{DeviceName: "Pixel 8"})
+
+	// Assert
+	assert.Nil(t, token)
+
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+	assert.NotEmpty(t, mfaErr.Token)
+	assert.Contains(t, mfaErr.Methods, services.MFAMethodTOTP)
+	env.authRepo.AssertNotCalled(t, "CreateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMFAVerify_TOTP(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	cred, secret := env.confirmedTOTP(t, 1)
+	env.mfaRepo.On("FindTOTP", uint(1)).Return(cred, nil)
+	env.challengeRepo.On("FetchMFAChallenge", "challenge-1").Return(&models.MFAChallenge{UserID: 1, DeviceName: "Pixel 8"}, nil)
+	env.challengeRepo.On("RecordMFAAttempt", "challenge-1").Return(int64(1), nil)
+	env.challengeRepo.On("CountMFAFailures", uint(1)).Return(int64(0), nil)
+	env.challengeRepo.On("ResetMFAFailures", uint(1)).Return(nil)
+	env.challengeRepo.On("DeleteMFAChallenge", "challenge-1").Return(true, nil)
+	env.mfaRepo.On("UseTOTPStep", uint(1), mock.Anything).Return(true, nil).Once()
+	env.authRepo.On("CreateAuth", uint(1), mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.MatchedBy(func(s *models.Session) bool { return s.DeviceName == "Pixel 8" })).Return(nil)
+
+	// Execute & Assert
+	code, _ := utils.TOTPCode(secret, time.Now())
+	token, err := service.Verify("challenge-1", services.MFAMethodTOTP, code, false)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+
+	// The same code cannot be replayed, even against another challenge
+	env.mfaRepo.On("UseTOTPStep", uint(1), mock.Anything).Return(false, nil)
+	env.challengeRepo.On("RecordMFAFailure", uint(1), mock.Anything).Return(int64(1), nil)
+	_, err = service.Verify("challenge-1", services.MFAMethodTOTP, code, false)
+	assert.ErrorIs(t, err, services.ErrInvalidMFACode)
+	env.challengeRepo.AssertNumberOfCalls(t, "RecordMFAFailure", 1)
+}
+
+func TestMFAVerify_LockoutAcrossChallenges(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	cred, secret := env.confirmedTOTP(t, 1)
+	env.mfaRepo.On("FindTOTP", uint(1)).Return(cred, nil)
+	env.challengeRepo.On("FetchMFAChallenge", "challenge-2").Return(&models.MFAChallenge{UserID: 1}, nil)
+	env.challengeRepo.On("RecordMFAAttempt", "challenge-2").Return(int64(1), nil)
+	// Earlier challenges of the user already failed ten times
+	env.challengeRepo.On("CountMFAFailures", uint(1)).Return(int64(10), nil)
+
+	// Execute: a fresh challenge with the right code
+	code, _ := utils.TOTPCode(secret, time.Now())
+	_, err := service.Verify("challenge-2", services.MFAMethodTOTP, code, false)
+
+	// Assert
+	assert.ErrorIs(t, err, services.ErrMFALocked)
+	env.mfaRepo.AssertNotCalled(t, "UseTOTPStep", mock.Anything, mock.Anything)
+	env.authRepo.AssertNotCalled(t, "CreateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMFAVerify_RememberDevice(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+
+	cred, secret := env.confirmedTOTP(t, user.ID)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(cred, nil)
+	env.challengeRepo.On("FetchMFAChallenge", "challenge-1").Return(&models.MFAChallenge{UserID: user.ID, DeviceName: "Pixel 8"}, nil)
+	env.challengeRepo.On("RecordMFAAttempt", "challenge-1").Return(int64(1), nil)
+	env.challengeRepo.On("CountMFAFailures", user.ID).Return(int64(0), nil)
+	env.challengeRepo.On("ResetMFAFailures", user.ID).Return(nil)
+	env.challengeRepo.On("DeleteMFAChallenge", "challenge-1").Return(true, nil)
+	env.mfaRepo.On("UseTOTPStep", user.ID, mock.Anything).Return(true, nil)
+	env.authRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	device := &models.TrustedDevice{}
+	env.mfaRepo.On("SaveTrustedDevice", mock.Anything).Run(func(args mock.Arguments) {
+		*device = *args.Get(0).(*models.TrustedDevice)
+	}).Return(nil).Once()
+
+	// Execute & Assert
+	code, _ := utils.TOTPCode(secret, time.Now())
+	token, err := service.Verify("challenge-1", services.MFAMethodTOTP, code, true)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.DeviceToken)
+	assert.Equal(t, "Pixel 8", device.Label)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), device.ExpiresAt, time.Minute)
+
+	// Only the hash of the token is stored
+	sum := sha256.Sum256([]byte(token.DeviceToken))
+	assert.Equal(t, hex.EncodeToString(sum[:]), device.TokenHash)
+
+	// The next password login from the device skips the second factor
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.mfaRepo.On("FindTrustedDevice", device.TokenHash).Return(device, nil)
+	env.mfaRepo.On("SaveTrustedDevice", mock.MatchedBy(func(d *models.TrustedDevice) bool { return d.LastUsedAt != nil })).Return(nil).Once()
+
+	client := services.ClientInfo{DeviceToken: token.DeviceToken}
+	token, err = env.authService.Login(user.Email, "password123", client)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+	env.challengeRepo.AssertNotCalled(t, "SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything)
+
+	// but not once the trust has expired
+	device.ExpiresAt = time.Now().Add(-time.Minute)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	_, err = env.authService.Login(user.Email, "password123", client)
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+}
+
+func TestRevokeTrustedDevice(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	env.mfaRepo.On("DeleteTrustedDevice", uint(1), uint(7)).Return(true, nil)
+	env.mfaRepo.On("DeleteTrustedDevice", uint(2), uint(7)).Return(false, nil)
+
+	// Execute & Assert
+	assert.NoError(t, env.authService.RevokeTrustedDevice(1, 7))
+	// Devices of other users are not found
+	assert.ErrorIs(t, env.authService.RevokeTrustedDevice(2, 7), services.ErrTrustedDeviceNotFound)
+}
+
+func TestMFAVerify_RecoveryCodeAndAttemptLimit(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	env.challengeRepo.On("FetchMFAChallenge", "challenge-1").Return(&models.MFAChallenge{UserID: 1}, nil)
+	env.challengeRepo.On("RecordMFAAttempt", "challenge-1").Return(int64(1), nil).Once()
+	env.challengeRepo.On("CountMFAFailures", uint(1)).Return(int64(0), nil)
+	env.challengeRepo.On("ResetMFAFailures", uint(1)).Return(nil)
+	env.challengeRepo.On("DeleteMFAChallenge", "challenge-1").Return(true, nil)
+	// Recovery codes are looked up by hash, ignoring case and dashes
+	sum := sha256.Sum256([]byte("abcdefghijklmnop"))
+	env.mfaRepo.On("UseRecoveryCode", uint(1), hex.EncodeToString(sum[:])).Return(true, nil).Once()
+	env.authRepo.On("CreateAuth", uint(1), mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	// Execute & Assert
+	token, err := service.Verify("challenge-1", services.MFAMethodRecoveryCode, "ABCD-efgh-ijkl-mnop", false)
+	assert.NoError(t, err)
+	assert.NotNil(t, token)
+
+	// The sixth attempt drops the challenge without checking the code
+	env.challengeRepo.On("RecordMFAAttempt", "challenge-1").Return(int64(6), nil)
+	_, err = service.Verify("challenge-1", services.MFAMethodRecoveryCode, "abcd-efgh-ijkl-mnop", false)
+	assert.ErrorIs(t, err, services.ErrMFAChallenge)
+	env.mfaRepo.AssertNumberOfCalls(t, "UseRecoveryCode", 1)
+}
+
+func TestMFAVerify_ExpiredDuringVerify(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	env.challengeRepo.On("FetchMFAChallenge", "challenge-1").Return(&models.MFAChallenge{UserID: 1}, nil)
+	// The challenge expired between the fetch and the attempt
+	env.challengeRepo.On("RecordMFAAttempt", "challenge-1").Return(int64(0), nil)
+
+	// Execute
+	_, err := service.Verify("challenge-1", services.MFAMethodRecoveryCode, "abcd-efgh-ijkl-mnop", false)
+
+	// Assert
+	assert.ErrorIs(t, err, services.ErrMFAChallenge)
+	env.mfaRepo.AssertNotCalled(t, "UseRecoveryCode", mock.Anything, mock.Anything)
+}
+
+func TestReauthenticate_StepUpWithTOTP(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+
+	cred, secret := env.confirmedTOTP(t, user.ID)
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(cred, nil)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: user.ID, AMR: []string{"pwd"}}, nil)
+
+	// The password alone is not enough: the challenge remembers the session
+	var challenge *models.MFAChallenge
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		challenge = args.Get(1).(*models.MFAChallenge)
+	}).Return(nil)
+
+	// Execute & Assert
+	_, err := env.authService.Reauthenticate(user.ID, "family-1", "password123", services.ClientInfo{})
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+	assert.Equal(t, "family-1", challenge.FamilyID)
+
+	env.challengeRepo.On("FetchMFAChallenge", mfaErr.Token).Return(challenge, nil)
+	env.challengeRepo.On("RecordMFAAttempt", mfaErr.Token).Return(int64(1), nil)
+	env.challengeRepo.On("CountMFAFailures", user.ID).Return(int64(0), nil)
+	env.challengeRepo.On("ResetMFAFailures", user.ID).Return(nil)
+	env.challengeRepo.On("DeleteMFAChallenge", mfaErr.Token).Return(true, nil)
+	env.mfaRepo.On("UseTOTPStep", user.ID, mock.Anything).Return(true, nil)
+	env.authRepo.On("UpdateSessionAuth", "family-1", []string{"pwd", "otp", "mfa"}, mock.Anything).Return(nil)
+	env.authRepo.On("RetireFamilyTokens", "family-1").Return(nil)
+	env.authRepo.On("CreateAuth", user.ID, "family-1", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("TouchSession", "family-1", mock.Anything, mock.Anything).Return(nil)
+
+	code, _ := utils.TOTPCode(secret, time.Now())
+	token, err := service.Verify(mfaErr.Token, services.MFAMethodTOTP, code, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "family-1", token.FamilyID)
+
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.ACRMFA, claims["acr"])
+	env.authRepo.AssertNotCalled(t, "SaveSession", mock.Anything)
+}
+
+// expectSMSCode stores the next code saved for destination in the returned
+// challenge, so the test can answer with it.
+func (e *testEnv) expectSMSCode(destination string) *models.OTPChallenge {
+	challenge := &models.OTPChallenge{}
+	e.challengeRepo.On("SaveOTPChallenge", destination, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*challenge = *args.Get(1).(*models.OTPChallenge)
+	}).Return(nil).Once()
+	e.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	e.expectOTPFailures(destination)
+	e.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(1), nil)
+	e.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil)
+	return challenge
+}
+
+func (e *testEnv) lastSMSCode(t *testing.T) string {
+	msg := e.sms.Last()
+	assert.NotNil(t, msg)
+	return msg.Body[len(msg.Body)-6:]
+}
+
+func TestSMS_EnrollAndConfirm(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	// Execute & Assert
+	assert.ErrorIs(t, service.EnrollSMS(1, "5551234567"), services.ErrInvalidPhone)
+
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.userRepo.On("UpdatePhone", user.ID, "+15551234567", (*time.Time)(nil)).Return(nil).Once()
+	env.challengeRepo.On("ThrottleOTP", "sms:+15551234567", mock.Anything).Return(true, nil).Once()
+	env.expectSMSCode("sms:user:1")
+
+	err := service.EnrollSMS(user.ID, "+15551234567")
+	assert.NoError(t, err)
+	assert.Equal(t, "+15551234567", env.sms.Last().To)
+
+	user.Phone = "+15551234567"
+	env.userRepo.On("UpdatePhone", user.ID, "+15551234567", mock.AnythingOfType("*time.Time")).Return(nil).Once()
+	err = service.ConfirmSMS(user.ID, env.lastSMSCode(t))
+	assert.NoError(t, err)
+	env.userRepo.AssertExpectations(t)
+
+	// The number shares its send limit with logins and other accounts
+	env.challengeRepo.On("ThrottleOTP", "sms:+15551234567", mock.Anything).Return(false, nil)
+	now := time.Now()
+	user.PhoneVerifiedAt = &now
+	assert.ErrorIs(t, service.SendSMSAccountCode(user.ID), services.ErrSMSThrottled)
+}
+
+func TestSMS_EnrollWithoutGateway(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := services.NewMFAService(env.userRepo, env.mfaRepo, env.challengeRepo, env.authService, env.webAuthnService(t), nil, env.cfg)
+
+	// Execute
+	err := service.EnrollSMS(1, "+15551234567")
+
+	// Assert: the number is not stored when no code can reach it
+	assert.ErrorIs(t, err, services.ErrSMSUnavailable)
+	env.userRepo.AssertNotCalled(t, "UpdatePhone", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLogin_SMSWhenTOTPNotEnrolled(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	hashedPassword, _ := utils.HashPassword("password123")
+	verifiedAt := time.Now()
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword, Phone: "+15551234567", PhoneVerifiedAt: &verifiedAt}
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// Execute & Assert
+	_, err := env.authService.Login(user.Email, "password123", services.ClientInfo{})
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+	assert.Equal(t, []string{services.MFAMethodSMS}, mfaErr.Methods)
+
+	env.challengeRepo.On("FetchMFAChallenge", mfaErr.Token).Return(&models.MFAChallenge{UserID: user.ID}, nil)
+	env.challengeRepo.On("RecordMFAAttempt", mfaErr.Token).Return(int64(1), nil)
+	env.challengeRepo.On("CountMFAFailures", user.ID).Return(int64(0), nil)
+	env.challengeRepo.On("ResetMFAFailures", user.ID).Return(nil)
+	env.challengeRepo.On("DeleteMFAChallenge", mfaErr.Token).Return(true, nil)
+	env.challengeRepo.On("ThrottleOTP", "sms:+15551234567", mock.Anything).Return(true, nil)
+	env.expectSMSCode("sms:mfa:" + mfaErr.Token)
+	env.authRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	assert.NoError(t, service.SendSMSLoginCode(mfaErr.Token))
+	token, err := service.Verify(mfaErr.Token, services.MFAMethodSMS, env.lastSMSCode(t), false)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+}
+
+func TestLogin_SMSNotOfferedWithoutGateway(t *testing.T) {
+	// Setup
+	cfg := mfaConfig()
+	cfg.SMSWebhookURL = ""
+	env := newTestEnv(t, cfg)
+
+	hashedPassword, _ := utils.HashPassword("password123")
+	verifiedAt := time.Now()
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword, Phone: "+15551234567", PhoneVerifiedAt: &verifiedAt}
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return([]models.WebAuthnCredential{{UserID: user.ID}}, nil)
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// Execute
+	_, err := env.authService.Login(user.Email, "password123", services.ClientInfo{})
+
+	// Assert: only factors that can be completed are offered
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+	assert.Equal(t, []string{services.MFAMethodWebAuthn}, mfaErr.Methods)
+}
+
+func TestLogin_SMSNotOfferedWithTOTP(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+
+	verifiedAt := time.Now()
+	user := &models.User{ID: 1, Phone: "+15551234567", PhoneVerifiedAt: &verifiedAt}
+	cred, _ := env.confirmedTOTP(t, user.ID)
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(cred, nil)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	env.challengeRepo.On("FetchMFAChallenge", "challenge-1").Return(&models.MFAChallenge{UserID: user.ID}, nil)
+
+	// Execute
+	err := service.SendSMSLoginCode("challenge-1")
+
+	// Assert
+	assert.ErrorIs(t, err, services.ErrMFANotEnrolled)
+	assert.Nil(t, env.sms.Last())
+}
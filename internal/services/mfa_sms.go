@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+)
+
+const smsCodeTTL = 5 * time.Minute
+
+var (
+	ErrInvalidPhone   = errors.New("phone number must be in E.164 format, e.g. +15551234567")
+	ErrSMSThrottled   = errors.New("a code was sent to this number recently, please wait before requesting another")
+	ErrSMSUnavailable = errors.New("SMS codes are not available")
+)
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Account codes confirm or disable the number; login codes answer an MFA
+// challenge and live next to it.
+func smsAccountDestination(userID uint) string {
+	return "sms:user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func smsLoginDestination(challengeToken string) string {
+	return "sms:mfa:" + challengeToken
+}
+
+// sendSMSCode texts a new code to phone and stores it under destination.
+// Sends are throttled per number, whichever account or login asks for them.
+func (s *MFAService) sendSMSCode(phone, destination string, userID uint) error {
+	if s.sms == nil {
+		return ErrSMSUnavailable
+	}
+	allowed, err := s.challengeRepo.ThrottleOTP("sms:"+phone, otpResendInterval)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrSMSThrottled
+	}
+
+	code, err := utils.GenerateNumericCode(otpDigits)
+	if err != nil {
+		return err
+	}
+	err = s.challengeRepo.SaveOTPChallenge(destination, &models.OTPChallenge{
+		UserID:    userID,
+		CodeHash:  hashOTP(code),
+		CreatedAt: time.Now(),
+	}, smsCodeTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.sms.Send(phone, fmt.Sprintf("%s verification code: %s", s.cfg.MFAIssuer, code)); err != nil {
+		s.challengeRepo.DeleteOTPChallenge(destination)
+		return err
+	}
+	return nil
+}
+
+// EnrollSMS stores an unverified number and texts it a code. Logins are not
+// affected until ConfirmSMS. Without an SMS gateway numbers are refused.
+func (s *MFAService) EnrollSMS(userID uint, phone string) error {
+	if s.sms == nil {
+		return ErrSMSUnavailable
+	}
+	if !e164.MatchString(phone) {
+		return ErrInvalidPhone
+	}
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PhoneVerifiedAt != nil {
+		return ErrMFAAlreadyEnrolled
+	}
+
+	if err := s.userRepo.UpdatePhone(userID, phone, nil); err != nil {
+		return err
+	}
+	return s.sendSMSCode(phone, smsAccountDestination(userID), userID)
+}
+
+// ConfirmSMS verifies the number with the code sent by EnrollSMS.
+func (s *MFAService) ConfirmSMS(userID uint, code string) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Phone == "" {
+		return ErrMFANotEnrolled
+	}
+	if user.PhoneVerifiedAt != nil {
+		return ErrMFAAlreadyEnrolled
+	}
+
+	if _, err := consumeOTP(s.challengeRepo, smsAccountDestination(userID), otpCode, code); err != nil {
+		return ErrInvalidMFACode
+	}
+	now := time.Now()
+	return s.userRepo.UpdatePhone(userID, user.Phone, &now)
+}
+
+// SendSMSAccountCode texts a code to the verified number, for DisableSMS.
+func (s *MFAService) SendSMSAccountCode(userID uint) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PhoneVerifiedAt == nil {
+		return ErrMFANotEnrolled
+	}
+	return s.sendSMSCode(user.Phone, smsAccountDestination(userID), userID)
+}
+
+// DisableSMS removes the number. Like DisableTOTP it requires a current
+// code, so a stolen access token alone cannot turn MFA off.
+func (s *MFAService) DisableSMS(userID uint, code string) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PhoneVerifiedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	if _, err := consumeOTP(s.challengeRepo, smsAccountDestination(userID), otpCode, code); err != nil {
+		return ErrInvalidMFACode
+	}
+	return s.userRepo.UpdatePhone(userID, "", nil)
+}
+
+// SendSMSLoginCode texts a code for a login waiting for its second factor.
+// It is answered through Verify with the sms method.
+func (s *MFAService) SendSMSLoginCode(challengeToken string) error {
+	challenge, err := s.challengeRepo.FetchMFAChallenge(challengeToken)
+	if err != nil {
+		return ErrMFAChallenge
+	}
+	user, err := s.userRepo.FindByID(challenge.UserID)
+	if err != nil {
+		return err
+	}
+
+	methods, err := s.authService.mfaMethods(user)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(methods, MFAMethodSMS) {
+		return ErrMFANotEnrolled
+	}
+	return s.sendSMSCode(user.Phone, smsLoginDestination(challengeToken), user.ID)
+}
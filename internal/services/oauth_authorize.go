@@ -0,0 +1,119 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+)
+
+const authCodeTTL = time.Minute
+
+// RFC 7636: 43-128 characters from the unreserved set
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// Authorize issues an authorization code for the user logged in with the
+// given session. The returned redirect URI is empty when the client or
+// redirect URI could not be validated; such errors must be shown to the user
+// instead of redirecting.
+func (s *OAuthService) Authorize(userID uint, sessionID string, req AuthorizeRequest) (string, string, error) {
+	client, err := s.clientRepo.FindByClientID(req.ClientID)
+	if err != nil {
+		return "", "", oauthError("invalid_request", "Unknown client_id")
+	}
+
+	redirectURI := req.RedirectURI
+	if redirectURI == "" {
+		// Only allowed to be omitted when exactly one URI is registered
+		if uris := strings.Fields(client.RedirectURIs); len(uris) == 1 {
+			redirectURI = uris[0]
+		}
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		return "", "", oauthError("invalid_request", "Invalid redirect_uri")
+	}
+
+	if req.ResponseType != "code" {
+		return redirectURI, "", oauthError("unsupported_response_type", "")
+	}
+	if !client.AllowsGrantType("authorization_code") {
+		return redirectURI, "", ErrUnauthorizedClient
+	}
+	if err := validateScope(client, req.Scope); err != nil {
+		return redirectURI, "", err
+	}
+	if hasScope(req.Scope, "openid") && !s.SupportsOpenID() {
+		return redirectURI, "", errOpenIDUnavailable
+	}
+
+	// PKCE is mandatory for every client, and only S256 is accepted
+	if req.CodeChallenge == "" {
+		return redirectURI, "", oauthError("invalid_request", "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return redirectURI, "", oauthError("invalid_request", "code_challenge_method must be S256")
+	}
+
+	// The code carries the latest authentication of the user's session
+	authTime := time.Now()
+	var amr []string
+	if session, err := s.authRepo.FetchSession(sessionID); err == nil && !session.AuthTime.IsZero() {
+		authTime = session.AuthTime
+		amr = session.AMR
+	}
+
+	code, err := utils.GenerateSecret(32)
+	if err != nil {
+		return redirectURI, "", oauthError("server_error", "")
+	}
+	err = s.oauthRepo.SaveAuthCode(code, &models.AuthorizationCode{
+		ClientID:            client.ClientID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            authTime,
+		AMR:                 amr,
+		CreatedAt:           time.Now(),
+	}, authCodeTTL)
+	if err != nil {
+		return redirectURI, "", oauthError("server_error", "")
+	}
+
+	return redirectURI, code, nil
+}
+
+func validateScope(client *models.Client, scope string) error {
+	for _, sc := range strings.Fields(scope) {
+		if !client.AllowsScope(sc) {
+			return oauthError("invalid_scope", "Scope not allowed: "+sc)
+		}
+	}
+	return nil
+}
+
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	deviceCodeTTL      = 10 * time.Minute
+	devicePollInterval = 5 * time.Second
+
+	// RFC 8628 section 6.1: consonants only, so codes never spell words and
+	// are easy to type on a TV remote
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+var ErrInvalidUserCode = errors.New("invalid or expired user code")
+
+// DeviceAuthorizationResponse is the RFC 8628 device authorization response.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceAuthorization starts the device flow for a client that cannot open a
+// browser.
+func (s *OAuthService) DeviceAuthorization(client *models.Client, scope string) (*DeviceAuthorizationResponse, error) {
+	if !client.AllowsGrantType(DeviceCodeGrantType) {
+		return nil, ErrUnauthorizedClient
+	}
+	if err := validateScope(client, scope); err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := utils.GenerateSecret(32)
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.oauthRepo.SaveDeviceAuthorization(deviceCode, &models.DeviceAuthorization{
+		ClientID:  client.ClientID,
+		Scope:     scope,
+		UserCode:  userCode,
+		Status:    models.DeviceAuthorizationPending,
+		ExpiresAt: time.Now().Add(deviceCodeTTL),
+	}, deviceCodeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURI := strings.TrimRight(s.cfg.Issuer, "/") + "/oauth/device"
+	display := userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                display,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + display,
+		ExpiresIn:               int64(deviceCodeTTL.Seconds()),
+		Interval:                int64(devicePollInterval.Seconds()),
+	}, nil
+}
+
+func generateUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts codes typed in lower case or with separators.
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
+
+// DeviceClient returns the pending authorization and the client asking for
+// it, so the user can check what they are about to approve.
+func (s *OAuthService) DeviceClient(userCode string) (*models.DeviceAuthorization, *models.Client, error) {
+	auth, err := s.oauthRepo.FindDeviceAuthorization(normalizeUserCode(userCode))
+	if err != nil || auth.Status != models.DeviceAuthorizationPending {
+		return nil, nil, ErrInvalidUserCode
+	}
+	client, err := s.clientRepo.FindByClientID(auth.ClientID)
+	if err != nil {
+		return nil, nil, ErrInvalidUserCode
+	}
+	return auth, client, nil
+}
+
+// ApproveDevice records the logged-in user's decision for a user code. The
+// device picks it up on its next poll. Like an authorization code, an
+// approval carries the latest authentication of the user's session.
+func (s *OAuthService) ApproveDevice(userID uint, sessionID, userCode string, approve bool) error {
+	auth, err := s.oauthRepo.FindDeviceAuthorization(normalizeUserCode(userCode))
+	if err != nil || auth.Status != models.DeviceAuthorizationPending {
+		return ErrInvalidUserCode
+	}
+
+	auth.Status = models.DeviceAuthorizationDenied
+	if approve {
+		auth.Status = models.DeviceAuthorizationApproved
+		auth.UserID = userID
+		auth.AuthTime = time.Now()
+		if session, err := s.authRepo.FetchSession(sessionID); err == nil && !session.AuthTime.IsZero() {
+			auth.AuthTime = session.AuthTime
+			auth.AMR = session.AMR
+		}
+	}
+	err = s.oauthRepo.UpdateDeviceAuthorization(auth)
+	if err == redis.Nil {
+		return ErrInvalidUserCode
+	}
+	return err
+}
+
+// deviceCodeGrant answers a device's poll. Tokens are the same pair a
+// password login issues, bound to the client and the approved scope.
+func (s *OAuthService) deviceCodeGrant(client *models.Client, req TokenRequest, device ClientInfo) (*TokenResponse, error) {
+	if req.DeviceCode == "" {
+		return nil, oauthError("invalid_request", "device_code is required")
+	}
+
+	auth, err := s.oauthRepo.FetchDeviceAuthorization(req.DeviceCode)
+	if err == redis.Nil {
+		return nil, oauthError("expired_token", "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if auth.ClientID != client.ClientID {
+		return nil, oauthError("invalid_grant", "Device code was issued to another client")
+	}
+
+	allowed, err := s.oauthRepo.ThrottleDevicePoll(req.DeviceCode, devicePollInterval)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, oauthError("slow_down", "")
+	}
+
+	switch auth.Status {
+	case models.DeviceAuthorizationPending:
+		return nil, oauthError("authorization_pending", "")
+	case models.DeviceAuthorizationDenied:
+		if _, err := s.oauthRepo.ConsumeDeviceAuthorization(req.DeviceCode); err != nil {
+			return nil, err
+		}
+		return nil, oauthError("access_denied", "")
+	}
+
+	consumed, err := s.oauthRepo.ConsumeDeviceAuthorization(req.DeviceCode)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		// A concurrent poll already redeemed it
+		return nil, oauthError("expired_token", "")
+	}
+
+	td, err := s.authService.StartSession(utils.Grant{
+		UserID:   auth.UserID,
+		ClientID: client.ClientID,
+		Scope:    auth.Scope,
+		AMR:      auth.AMR,
+		AuthTime: auth.AuthTime,
+	}, device)
+	if err != nil {
+		return nil, err
+	}
+	return newTokenResponse(td, auth.Scope), nil
+}
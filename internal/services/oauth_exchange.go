@@ -0,0 +1,120 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	AccessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeGrant implements RFC 8693 delegation: a service presents the
+// access token it received and gets a token for the same subject that only
+// the audience accepts, with at most the scope of the original token.
+func (s *OAuthService) tokenExchangeGrant(client *models.Client, req TokenRequest, _ ClientInfo) (*TokenResponse, error) {
+	if !client.IsConfidential() {
+		return nil, ErrUnauthorizedClient
+	}
+	if req.SubjectToken == "" || req.SubjectTokenType == "" {
+		return nil, oauthError("invalid_request", "subject_token and subject_token_type are required")
+	}
+	if req.SubjectTokenType != AccessTokenType {
+		return nil, oauthError("invalid_request", "Unsupported subject_token_type")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != AccessTokenType {
+		return nil, oauthError("invalid_request", "Unsupported requested_token_type")
+	}
+	if req.Audience == "" {
+		return nil, oauthError("invalid_request", "audience is required")
+	}
+	if _, err := s.clientRepo.FindByClientID(req.Audience); err != nil {
+		return nil, oauthError("invalid_target", "Unknown audience")
+	}
+
+	// Same checks as AuthMiddleware: valid signature and not revoked
+	subject, err := s.keys.Access.Parse(req.SubjectToken)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "Invalid subject_token")
+	}
+	accessUuid, _ := subject["access_uuid"].(string)
+	if val, err := s.authRepo.FetchAuth(accessUuid); accessUuid == "" || err != nil || val == "" {
+		return nil, oauthError("invalid_grant", "subject_token expired or revoked")
+	}
+	// An audience-restricted token may only be exchanged by its audience
+	if aud, ok := subject["aud"].(string); ok && aud != client.ClientID {
+		return nil, oauthError("invalid_grant", "subject_token was issued for another audience")
+	}
+
+	scope, err := exchangeScope(client, subject, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never outlive the subject token
+	expiresAt := time.Now().Add(utils.AccessTokenTTL)
+	if exp, ok := subject["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiresAt) {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	td, err := utils.GenerateDelegatedToken(utils.Delegation{
+		Subject:   subject,
+		ActorID:   client.ClientID,
+		Audience:  req.Audience,
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+	}, s.keys)
+	if err != nil {
+		return nil, err
+	}
+	// Tokens of a user session are revoked together with it, on logout or
+	// password reset
+	if td.FamilyID != "" {
+		err = s.authRepo.CreateDelegatedAuth(td.FamilyID, client.ClientID, td.AccessUuid, td.AtExpires)
+	} else {
+		err = s.authRepo.CreateClientAuth(client.ClientID, td.AccessUuid, td.AtExpires)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := newTokenResponse(td, scope)
+	resp.IssuedTokenType = AccessTokenType
+	return resp, nil
+}
+
+// exchangeScope narrows the requested scope to what both the subject token
+// and the acting client allow. First-party tokens carry no scope and are not
+// limited by it. Without a requested scope the client gets the intersection.
+func exchangeScope(client *models.Client, subject jwt.MapClaims, requested string) (string, error) {
+	subjectScope, scoped := subject["scope"].(string)
+	allowed := func(sc string) bool {
+		return client.AllowsScope(sc) && (!scoped || hasScope(subjectScope, sc))
+	}
+
+	if requested == "" {
+		var granted []string
+		for _, sc := range strings.Fields(client.Scopes) {
+			if allowed(sc) {
+				granted = append(granted, sc)
+			}
+		}
+		if len(granted) == 0 {
+			return "", oauthError("invalid_scope", "No scope shared by the subject token and the client")
+		}
+		return strings.Join(granted, " "), nil
+	}
+
+	for _, sc := range strings.Fields(requested) {
+		if !allowed(sc) {
+			return "", oauthError("invalid_scope", "Scope not allowed: "+sc)
+		}
+	}
+	return requested, nil
+}
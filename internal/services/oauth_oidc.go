@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInsufficientScope = errors.New("insufficient_scope")
+
+var errOpenIDUnavailable = oauthError("invalid_scope", "openid requires an asymmetric signing key")
+
+const idTokenTTL = time.Hour
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// userClaims returns the standard claims of the user released by the scope:
+// "email" releases email, "profile" releases name.
+func userClaims(user *models.User, scope string) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub": strconv.FormatUint(uint64(user.ID), 10),
+	}
+	if hasScope(scope, "email") {
+		claims["email"] = user.Email
+	}
+	if hasScope(scope, "profile") {
+		claims["name"] = user.Name
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	return claims
+}
+
+// SupportsOpenID reports whether ID tokens can be issued. They are signed
+// with the access token key ring, which relying parties can only verify
+// through the JWKS when its active key is asymmetric.
+func (s *OAuthService) SupportsOpenID() bool {
+	return s.keys.Access.Active().Asymmetric()
+}
+
+// generateIDToken issues an OpenID Connect ID token for the redeemed code. It
+// is signed with the access token key ring and verifiable through the JWKS.
+func (s *OAuthService) generateIDToken(clientID string, authCode *models.AuthorizationCode) (string, error) {
+	user, err := s.userRepo.FindByID(authCode.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := userClaims(user, authCode.Scope)
+	claims["iss"] = s.cfg.Issuer
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(idTokenTTL).Unix()
+	claims["auth_time"] = authCode.AuthTime.Unix()
+	if len(authCode.AMR) > 0 {
+		claims["amr"] = authCode.AMR
+		claims["acr"] = utils.ACR(authCode.AMR)
+	}
+	if authCode.Nonce != "" {
+		claims["nonce"] = authCode.Nonce
+	}
+	return s.keys.Access.Sign(claims)
+}
+
+// UserInfo returns the claims of the user for the OIDC UserInfo endpoint.
+// Tokens issued to OAuth clients need the openid scope; first-party tokens
+// carry no scope and get every claim.
+func (s *OAuthService) UserInfo(userID uint, scope string, firstParty bool) (jwt.MapClaims, error) {
+	if firstParty {
+		scope = "openid email profile"
+	}
+	if !hasScope(scope, "openid") {
+		return nil, ErrInsufficientScope
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return userClaims(user, scope), nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"log"
+	"strconv"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OAuthError is an RFC 6749 error response.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+var (
+	ErrInvalidClient      = oauthError("invalid_client", "Client authentication failed")
+	ErrUnauthorizedClient = oauthError("unauthorized_client", "")
+)
+
+// Introspection is the RFC 7662 token introspection response.
+type Introspection struct {
+	Active    bool        `json:"active"`
+	Sub       string      `json:"sub,omitempty"`
+	Exp       int64       `json:"exp,omitempty"`
+	Scope     string      `json:"scope,omitempty"`
+	ClientID  string      `json:"client_id,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	Aud       string      `json:"aud,omitempty"`
+	Act       interface{} `json:"act,omitempty"`
+}
+
+type OAuthService struct {
+	clientRepo  repository.ClientRepository
+	oauthRepo   repository.OAuthRepository
+	authRepo    repository.AuthRepository
+	userRepo    repository.UserRepository
+	authService *AuthService
+	keys        *utils.TokenKeys
+	cfg         *config.Config
+}
+
+func NewOAuthService(clientRepo repository.ClientRepository, oauthRepo repository.OAuthRepository, authRepo repository.AuthRepository, userRepo repository.UserRepository, authService *AuthService, keys *utils.TokenKeys, cfg *config.Config) *OAuthService {
+	return &OAuthService{
+		clientRepo:  clientRepo,
+		oauthRepo:   oauthRepo,
+		authRepo:    authRepo,
+		userRepo:    userRepo,
+		authService: authService,
+		keys:        keys,
+		cfg:         cfg,
+	}
+}
+
+// IdentifyClient authenticates confidential clients and accepts public
+// clients by their client_id alone.
+func (s *OAuthService) IdentifyClient(clientID, secret string) (*models.Client, error) {
+	client, err := s.clientRepo.FindByClientID(clientID)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	if !client.IsConfidential() {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+	return s.AuthenticateClient(clientID, secret)
+}
+
+// AuthenticateClient verifies the credentials of a confidential client.
+func (s *OAuthService) AuthenticateClient(clientID, secret string) (*models.Client, error) {
+	client, err := s.clientRepo.FindByClientID(clientID)
+	if err != nil || !client.IsConfidential() {
+		return nil, ErrInvalidClient
+	}
+
+	match, err := utils.VerifyPassword(secret, client.SecretHash)
+	if err != nil || !match {
+		return nil, ErrInvalidClient
+	}
+	s.rehashSecret(client, secret)
+	return client, nil
+}
+
+// rehashSecret replaces a verified secret hash that has a pepper, as the
+// ones created before HashSecret, or weaker parameters than new hashes get.
+// A failure leaves the old hash in place for the next request.
+func (s *OAuthService) rehashSecret(client *models.Client, secret string) {
+	if !utils.NeedsSecretRehash(client.SecretHash) {
+		return
+	}
+	var rehashed bool
+	hashed, err := utils.HashSecret(secret)
+	if err == nil {
+		rehashed, err = s.clientRepo.RehashSecret(client.ID, client.SecretHash, hashed)
+	}
+	if err != nil {
+		log.Printf("Failed to rehash secret of client %s: %v", client.ClientID, err)
+		return
+	}
+	if rehashed {
+		client.SecretHash = hashed
+	}
+}
+
+// parseToken verifies a token against the access and refresh key rings, in
+// the order suggested by the token_type_hint.
+func (s *OAuthService) parseToken(token, tokenTypeHint string) (jwt.MapClaims, string) {
+	rings := []struct {
+		tokenType string
+		keys      *utils.KeySet
+		uuidClaim string
+	}{
+		{"access_token", s.keys.Access, "access_uuid"},
+		{"refresh_token", s.keys.Refresh, "refresh_uuid"},
+	}
+	if tokenTypeHint == "refresh_token" {
+		rings[0], rings[1] = rings[1], rings[0]
+	}
+
+	for _, ring := range rings {
+		claims, err := ring.keys.Parse(token)
+		if err != nil {
+			continue
+		}
+		if _, ok := claims[ring.uuidClaim].(string); ok {
+			return claims, ring.tokenType
+		}
+	}
+	return nil, ""
+}
+
+func tokenUuid(claims jwt.MapClaims, tokenType string) string {
+	if tokenType == "refresh_token" {
+		return claims["refresh_uuid"].(string)
+	}
+	return claims["access_uuid"].(string)
+}
+
+// Introspect reports whether an access or refresh token is active: its
+// signature must verify and its metadata must still be in Redis, the same
+// check AuthMiddleware performs.
+func (s *OAuthService) Introspect(token, tokenTypeHint string) *Introspection {
+	claims, tokenType := s.parseToken(token, tokenTypeHint)
+	if claims == nil {
+		return &Introspection{Active: false}
+	}
+	if val, err := s.authRepo.FetchAuth(tokenUuid(claims, tokenType)); err != nil || val == "" {
+		return &Introspection{Active: false}
+	}
+
+	result := &Introspection{Active: true, TokenType: tokenType}
+	if claims["subject_type"] == utils.SubjectClient {
+		result.Sub, _ = claims["sub"].(string)
+	} else if userID, ok := claims["user_id"].(float64); ok {
+		result.Sub = strconv.FormatUint(uint64(userID), 10)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		result.Exp = int64(exp)
+	}
+	result.Scope, _ = claims["scope"].(string)
+	result.ClientID, _ = claims["client_id"].(string)
+	result.Aud, _ = claims["aud"].(string)
+	result.Act = claims["act"]
+	return result
+}
+
+// Revoke implements RFC 7009. Invalid or unknown tokens are not an error.
+// Revoking a refresh token revokes its whole family, including the access
+// token issued with it.
+func (s *OAuthService) Revoke(client *models.Client, token, tokenTypeHint string) error {
+	claims, tokenType := s.parseToken(token, tokenTypeHint)
+	if claims == nil {
+		return nil
+	}
+
+	// Clients may only revoke their own tokens, which leaves out the
+	// first-party tokens of the user's sessions
+	if issuedTo, _ := claims["client_id"].(string); issuedTo != client.ClientID {
+		return ErrUnauthorizedClient
+	}
+
+	if familyID, _ := claims["family_id"].(string); tokenType == "refresh_token" && familyID != "" {
+		return s.authRepo.RevokeFamily(familyID)
+	}
+	return s.authRepo.DeleteAuth(tokenUuid(claims, tokenType))
+}
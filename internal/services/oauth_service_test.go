@@ -0,0 +1,587 @@
+package services_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAuthenticateClient(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+
+	secretHash, _ := utils.HashSecret("s3cret")
+	env.clientRepo.On("FindByClientID", "gateway").Return(&models.Client{ClientID: "gateway", SecretHash: secretHash}, nil)
+	env.clientRepo.On("FindByClientID", "spa").Return(&models.Client{ClientID: "spa"}, nil)
+	env.clientRepo.On("FindByClientID", "unknown").Return(nil, errors.New("record not found"))
+
+	// Execute & Assert
+	client, err := service.AuthenticateClient("gateway", "s3cret")
+	assert.NoError(t, err)
+	assert.Equal(t, "gateway", client.ClientID)
+
+	_, err = service.AuthenticateClient("gateway", "wrong")
+	assert.ErrorIs(t, err, services.ErrInvalidClient)
+
+	// Public clients cannot authenticate
+	_, err = service.AuthenticateClient("spa", "")
+	assert.ErrorIs(t, err, services.ErrInvalidClient)
+
+	_, err = service.AuthenticateClient("unknown", "s3cret")
+	assert.ErrorIs(t, err, services.ErrInvalidClient)
+}
+
+func TestAuthenticateClient_RehashesPepperedSecret(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	t.Cleanup(func() { utils.SetPeppers(nil) })
+
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{{Version: 1, Secret: []byte(strings.Repeat("a", 32))}}))
+	oldHash, _ := utils.HashPassword("s3cret")
+	assert.Contains(t, oldHash, ",keyid=1$")
+	client := &models.Client{ID: 3, ClientID: "gateway", SecretHash: oldHash}
+	env.clientRepo.On("FindByClientID", "gateway").Return(client, nil)
+	var newHash string
+	env.clientRepo.On("RehashSecret", client.ID, oldHash, mock.Anything).Run(func(args mock.Arguments) {
+		newHash = args.String(2)
+	}).Return(true, nil).Once()
+
+	// Execute
+	_, err := service.AuthenticateClient("gateway", "s3cret")
+
+	// Assert: the new hash has no pepper and needs no further rehash
+	assert.NoError(t, err)
+	assert.NotContains(t, newHash, "keyid")
+	assert.False(t, utils.NeedsSecretRehash(newHash))
+	match, err := utils.VerifyPassword("s3cret", newHash)
+	assert.NoError(t, err)
+	assert.True(t, match)
+
+	// Rehashed secrets no longer depend on the pepper
+	assert.NoError(t, utils.SetPeppers(nil))
+	_, err = service.AuthenticateClient("gateway", "s3cret")
+	assert.NoError(t, err)
+	env.clientRepo.AssertNumberOfCalls(t, "RehashSecret", 1)
+}
+
+func TestIntrospect(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+
+	td, err := utils.GenerateToken(utils.Grant{UserID: 7, FamilyID: "family-1"}, env.keys)
+	assert.NoError(t, err)
+
+	env.authRepo.On("FetchAuth", td.AccessUuid).Return("7", nil)
+	env.authRepo.On("FetchAuth", td.RefreshUuid).Return("", errors.New("redis: nil"))
+
+	// Execute & Assert: active access token
+	result := service.Introspect(td.AccessToken, "")
+	assert.True(t, result.Active)
+	assert.Equal(t, "7", result.Sub)
+	assert.Equal(t, td.AtExpires, result.Exp)
+	assert.Equal(t, "access_token", result.TokenType)
+
+	// Revoked refresh token
+	result = service.Introspect(td.RefreshToken, "refresh_token")
+	assert.False(t, result.Active)
+	assert.Empty(t, result.Sub)
+
+	// Garbage
+	assert.False(t, service.Introspect("not-a-token", "").Active)
+}
+
+func TestRevoke(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	client := &models.Client{ClientID: "mobile"}
+
+	td, err := utils.GenerateToken(utils.Grant{UserID: 7, FamilyID: "family-1", ClientID: "mobile"}, env.keys)
+	assert.NoError(t, err)
+
+	env.authRepo.On("DeleteAuth", td.AccessUuid).Return(nil)
+	env.authRepo.On("RevokeFamily", "family-1").Return(nil)
+
+	// Execute & Assert: access token only deletes itself
+	assert.NoError(t, service.Revoke(client, td.AccessToken, "access_token"))
+	env.authRepo.AssertCalled(t, "DeleteAuth", td.AccessUuid)
+
+	// Refresh token revokes the family, including its access token
+	assert.NoError(t, service.Revoke(client, td.RefreshToken, ""))
+	env.authRepo.AssertCalled(t, "RevokeFamily", "family-1")
+
+	// Unknown tokens are not an error
+	assert.NoError(t, service.Revoke(client, "not-a-token", "refresh_token"))
+
+	// Tokens issued to another client are refused
+	foreign, err := env.keys.Access.Sign(jwt.MapClaims{"access_uuid": "access-2", "client_id": "other"})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, service.Revoke(client, foreign, ""), services.ErrUnauthorizedClient)
+	env.authRepo.AssertNotCalled(t, "DeleteAuth", "access-2")
+
+	// So are the first-party tokens of the user's own sessions
+	firstParty, err := utils.GenerateToken(utils.Grant{UserID: 7, FamilyID: "family-2"}, env.keys)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, service.Revoke(client, firstParty.AccessToken, ""), services.ErrUnauthorizedClient)
+	assert.ErrorIs(t, service.Revoke(client, firstParty.RefreshToken, "refresh_token"), services.ErrUnauthorizedClient)
+	env.authRepo.AssertNotCalled(t, "DeleteAuth", firstParty.AccessUuid)
+	env.authRepo.AssertNotCalled(t, "RevokeFamily", "family-2")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+
+	client := &models.Client{
+		ClientID:     "spa",
+		RedirectURIs: "https://app.example.com/callback",
+		Scopes:       "profile orders",
+		GrantTypes:   "authorization_code refresh_token",
+	}
+	env.clientRepo.On("FindByClientID", "spa").Return(client, nil)
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: 1}, nil)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" // RFC 7636 appendix B
+
+	req := services.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "spa",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "profile",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: "S256",
+	}
+
+	// Execute & Assert: unregistered redirect URIs are never redirected to
+	bad := req
+	bad.RedirectURI = "https://evil.example.com/callback"
+	redirectURI, _, err := service.Authorize(1, "family-1", bad)
+	assert.Empty(t, redirectURI)
+	assert.Error(t, err)
+
+	// plain PKCE is rejected
+	plain := req
+	plain.CodeChallengeMethod = "plain"
+	redirectURI, _, err = service.Authorize(1, "family-1", plain)
+	assert.Equal(t, req.RedirectURI, redirectURI)
+	assert.Error(t, err)
+
+	// Scopes outside the client's configuration are rejected
+	wide := req
+	wide.Scope = "profile admin"
+	_, _, err = service.Authorize(1, "family-1", wide)
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_scope", oerr.Code)
+
+	var saved *models.AuthorizationCode
+	env.oauthRepo.On("SaveAuthCode", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(1).(*models.AuthorizationCode)
+	}).Return(nil)
+
+	redirectURI, code, err := service.Authorize(1, "family-1", req)
+	assert.NoError(t, err)
+	assert.Equal(t, req.RedirectURI, redirectURI)
+	assert.NotEmpty(t, code)
+
+	env.oauthRepo.On("ConsumeAuthCode", code).Return(saved, nil).Once()
+	env.oauthRepo.On("ConsumeAuthCode", code).Return(nil, errors.New("redis: nil"))
+	env.authRepo.On("CreateAuth", uint(1), mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	tokenReq := services.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  req.RedirectURI,
+		CodeVerifier: verifier,
+	}
+	token, err := service.Token(client, tokenReq, services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.Equal(t, "profile", token.Scope)
+
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "spa", claims["client_id"])
+	assert.Equal(t, "profile", claims["scope"])
+
+	// Codes are single use
+	_, err = service.Token(client, tokenReq, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_grant", oerr.Code)
+}
+
+func TestAuthorizationCodeGrant_WrongVerifier(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	client := &models.Client{ClientID: "spa", GrantTypes: "authorization_code"}
+
+	env.oauthRepo.On("ConsumeAuthCode", "code-1").Return(&models.AuthorizationCode{
+		ClientID:            "spa",
+		UserID:              1,
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+	}, nil)
+
+	// Execute
+	_, err := service.Token(client, services.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         "code-1",
+		CodeVerifier: "wrong-verifier-wrong-verifier-wrong-verifier",
+	}, services.ClientInfo{})
+
+	// Assert
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_grant", oerr.Code)
+	env.authRepo.AssertNotCalled(t, "CreateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAuthorizationCodeGrant_IDToken(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com", JWTAlgorithm: "ES256"})
+	service := env.oauthService()
+	client := &models.Client{ClientID: "spa", GrantTypes: "authorization_code"}
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	env.oauthRepo.On("ConsumeAuthCode", "code-1").Return(&models.AuthorizationCode{
+		ClientID:            "spa",
+		UserID:              1,
+		Scope:               "openid email",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+		Nonce:               "n-0S6_WzA2Mj",
+		AuthTime:            authTime,
+	}, nil)
+	env.userRepo.On("FindByID", uint(1)).Return(&models.User{ID: 1, Email: "john@example.com", Name: "John"}, nil)
+	env.authRepo.On("CreateAuth", uint(1), mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	// Execute
+	token, err := service.Token(client, services.TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         "code-1",
+		CodeVerifier: "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+	}, services.ClientInfo{})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.IDToken)
+
+	claims, err := env.keys.Access.Parse(token.IDToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com", claims["iss"])
+	assert.Equal(t, "spa", claims["aud"])
+	assert.Equal(t, "1", claims["sub"])
+	assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+	assert.Equal(t, float64(authTime.Unix()), claims["auth_time"])
+	assert.Equal(t, "john@example.com", claims["email"])
+	assert.NotContains(t, claims, "name") // profile scope not granted
+}
+
+func TestAuthorize_OpenIDNeedsAsymmetricKey(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	client := &models.Client{
+		ClientID:     "spa",
+		RedirectURIs: "https://app.example.com/callback",
+		Scopes:       "openid email",
+		GrantTypes:   "authorization_code",
+	}
+	env.clientRepo.On("FindByClientID", "spa").Return(client, nil)
+
+	// Execute
+	_, _, err := service.Authorize(1, "family-1", services.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "spa",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid email",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+	})
+
+	// Assert: relying parties could not verify an HS256 ID token
+	assert.False(t, service.SupportsOpenID())
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_scope", oerr.Code)
+	env.oauthRepo.AssertNotCalled(t, "SaveAuthCode", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUserInfo(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	env.userRepo.On("FindByID", uint(1)).Return(&models.User{ID: 1, Email: "john@example.com", Name: "John"}, nil)
+
+	// Execute & Assert
+	claims, err := service.UserInfo(1, "openid profile", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "John", claims["name"])
+	assert.NotContains(t, claims, "email")
+
+	_, err = service.UserInfo(1, "orders", false)
+	assert.ErrorIs(t, err, services.ErrInsufficientScope)
+
+	// First-party tokens get every claim
+	claims, err = service.UserInfo(1, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", claims["email"])
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+
+	secretHash, _ := utils.HashSecret("s3cret")
+	client := &models.Client{
+		ClientID:   "orders",
+		SecretHash: secretHash,
+		Scopes:     "payments:read payments:write",
+		GrantTypes: "client_credentials",
+	}
+	env.authRepo.On("CreateClientAuth", "orders", mock.Anything, mock.Anything).Return(nil)
+
+	// Execute & Assert: narrower scope than registered
+	token, err := service.Token(client, services.TokenRequest{GrantType: "client_credentials", Scope: "payments:read"}, services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.Empty(t, token.RefreshToken)
+	assert.Equal(t, "payments:read", token.Scope)
+
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.SubjectClient, claims["subject_type"])
+	assert.Equal(t, "orders", claims["sub"])
+	assert.NotContains(t, claims, "user_id")
+
+	// Scopes outside the client configuration
+	_, err = service.Token(client, services.TokenRequest{GrantType: "client_credentials", Scope: "admin"}, services.ClientInfo{})
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_scope", oerr.Code)
+
+	// Grant types must be enabled for the client, and public clients never qualify
+	_, err = service.Token(&models.Client{ClientID: "spa", GrantTypes: "authorization_code"}, services.TokenRequest{GrantType: "client_credentials"}, services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrUnauthorizedClient)
+	_, err = service.Token(&models.Client{ClientID: "spa", GrantTypes: "client_credentials"}, services.TokenRequest{GrantType: "client_credentials"}, services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrUnauthorizedClient)
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	client := &models.Client{ClientID: "cli", Scopes: "profile", GrantTypes: services.DeviceCodeGrantType}
+
+	var saved *models.DeviceAuthorization
+	env.oauthRepo.On("SaveDeviceAuthorization", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		saved = args.Get(1).(*models.DeviceAuthorization)
+	}).Return(nil)
+
+	// Execute & Assert
+	resp, err := service.DeviceAuthorization(client, "profile")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.DeviceCode)
+	assert.Regexp(t, `^[B-Z]{4}-[B-Z]{4}$`, resp.UserCode)
+	assert.Equal(t, "https://auth.example.com/oauth/device", resp.VerificationURI)
+	assert.Equal(t, models.DeviceAuthorizationPending, saved.Status)
+
+	pollReq := services.TokenRequest{GrantType: services.DeviceCodeGrantType, DeviceCode: resp.DeviceCode}
+	env.oauthRepo.On("FetchDeviceAuthorization", resp.DeviceCode).Return(saved, nil)
+
+	// Pending until the user decides, and too fast polls are told to slow down
+	env.oauthRepo.On("ThrottleDevicePoll", resp.DeviceCode, mock.Anything).Return(true, nil).Once()
+	_, err = service.Token(client, pollReq, services.ClientInfo{})
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "authorization_pending", oerr.Code)
+
+	env.oauthRepo.On("ThrottleDevicePoll", resp.DeviceCode, mock.Anything).Return(false, nil).Once()
+	_, err = service.Token(client, pollReq, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "slow_down", oerr.Code)
+
+	// The user types the code in lower case without the dash
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	env.authRepo.On("FetchSession", "family-1").Return(&models.Session{ID: "family-1", UserID: 7, AMR: []string{"pwd", "otp", "mfa"}, AuthTime: authTime}, nil)
+	env.oauthRepo.On("FindDeviceAuthorization", saved.UserCode).Return(saved, nil)
+	env.oauthRepo.On("UpdateDeviceAuthorization", saved).Return(nil)
+	typed := strings.ToLower(strings.ReplaceAll(resp.UserCode, "-", ""))
+	assert.NoError(t, service.ApproveDevice(7, "family-1", typed, true))
+	assert.Equal(t, models.DeviceAuthorizationApproved, saved.Status)
+
+	// Approved codes cannot be approved again
+	assert.ErrorIs(t, service.ApproveDevice(8, "family-2", resp.UserCode, true), services.ErrInvalidUserCode)
+
+	env.oauthRepo.On("ThrottleDevicePoll", resp.DeviceCode, mock.Anything).Return(true, nil)
+	env.oauthRepo.On("ConsumeDeviceAuthorization", resp.DeviceCode).Return(true, nil).Once()
+	env.authRepo.On("CreateAuth", uint(7), mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	token, err := service.Token(client, pollReq, services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.RefreshToken)
+
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(7), claims["user_id"])
+	assert.Equal(t, "cli", claims["client_id"])
+	// The tokens carry how the approving user signed in
+	assert.Equal(t, []interface{}{"pwd", "otp", "mfa"}, claims["amr"])
+	assert.Equal(t, float64(authTime.Unix()), claims["auth_time"])
+
+	// Tokens are issued once
+	env.oauthRepo.On("ConsumeDeviceAuthorization", resp.DeviceCode).Return(false, nil)
+	_, err = service.Token(client, pollReq, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "expired_token", oerr.Code)
+}
+
+func TestDeviceCodeGrant_Denied(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+	client := &models.Client{ClientID: "cli", GrantTypes: services.DeviceCodeGrantType}
+
+	env.oauthRepo.On("FetchDeviceAuthorization", "device-1").Return(&models.DeviceAuthorization{
+		ClientID: "cli",
+		UserCode: "BCDFGHJK",
+		Status:   models.DeviceAuthorizationDenied,
+	}, nil)
+	env.oauthRepo.On("ThrottleDevicePoll", "device-1", mock.Anything).Return(true, nil)
+	env.oauthRepo.On("ConsumeDeviceAuthorization", "device-1").Return(true, nil)
+
+	// Execute & Assert
+	_, err := service.Token(client, services.TokenRequest{GrantType: services.DeviceCodeGrantType, DeviceCode: "device-1"}, services.ClientInfo{})
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "access_denied", oerr.Code)
+
+	// Device codes are bound to the client they were issued to
+	other := &models.Client{ClientID: "other", GrantTypes: services.DeviceCodeGrantType}
+	_, err = service.Token(other, services.TokenRequest{GrantType: services.DeviceCodeGrantType, DeviceCode: "device-1"}, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_grant", oerr.Code)
+}
+
+func TestTokenExchangeGrant(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+
+	secretHash, _ := utils.HashSecret("s3cret")
+	orders := &models.Client{
+		ClientID:   "orders",
+		SecretHash: secretHash,
+		Scopes:     "payments:read payments:write",
+		GrantTypes: services.TokenExchangeGrantType,
+	}
+	env.clientRepo.On("FindByClientID", "payments").Return(&models.Client{ClientID: "payments"}, nil)
+	env.clientRepo.On("FindByClientID", "unknown").Return(nil, errors.New("record not found"))
+
+	// The user's token, issued to the web app with a broader scope
+	subject, err := utils.GenerateToken(utils.Grant{UserID: 7, FamilyID: "family-1", ClientID: "web", Scope: "profile payments:read"}, env.keys)
+	assert.NoError(t, err)
+	env.authRepo.On("FetchAuth", subject.AccessUuid).Return("7", nil)
+	env.authRepo.On("CreateDelegatedAuth", "family-1", "orders", mock.Anything, mock.Anything).Return(nil)
+
+	// Execute & Assert
+	req := services.TokenRequest{
+		GrantType:        services.TokenExchangeGrantType,
+		SubjectToken:     subject.AccessToken,
+		SubjectTokenType: services.AccessTokenType,
+		Audience:         "payments",
+	}
+	token, err := service.Token(orders, req, services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.Equal(t, services.AccessTokenType, token.IssuedTokenType)
+	assert.Empty(t, token.RefreshToken)
+	// Only scopes both the subject token and the acting client hold
+	assert.Equal(t, "payments:read", token.Scope)
+
+	claims, err := env.keys.Access.Parse(token.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(7), claims["user_id"])
+	assert.Equal(t, utils.SubjectUser, claims["subject_type"])
+	assert.Equal(t, "payments", claims["aud"])
+	assert.Equal(t, "orders", claims["client_id"])
+	assert.Equal(t, map[string]interface{}{"sub": "orders"}, claims["act"])
+	assert.LessOrEqual(t, int64(claims["exp"].(float64)), subject.AtExpires)
+	// Indexed under the subject's family, so logging out revokes it too
+	env.authRepo.AssertCalled(t, "CreateDelegatedAuth", "family-1", "orders", claims["access_uuid"], mock.Anything)
+
+	var oerr *services.OAuthError
+
+	// Scopes the subject token does not hold cannot be requested
+	wide := req
+	wide.Scope = "payments:write"
+	_, err = service.Token(orders, wide, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_scope", oerr.Code)
+
+	// Nor can a client that shares no scope with the subject token
+	inventory := &models.Client{ClientID: "inventory", SecretHash: secretHash, Scopes: "stock:read", GrantTypes: services.TokenExchangeGrantType}
+	_, err = service.Token(inventory, req, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_scope", oerr.Code)
+
+	unknown := req
+	unknown.Audience = "unknown"
+	_, err = service.Token(orders, unknown, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_target", oerr.Code)
+
+	// The exchanged token is for payments only; orders cannot exchange it again
+	env.authRepo.On("FetchAuth", claims["access_uuid"]).Return("client:orders", nil)
+	again := req
+	again.SubjectToken = token.AccessToken
+	_, err = service.Token(orders, again, services.ClientInfo{})
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_grant", oerr.Code)
+}
+
+func TestTokenExchangeGrant_RevokedSubject(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, &config.Config{Issuer: "https://auth.example.com"})
+	service := env.oauthService()
+
+	secretHash, _ := utils.HashSecret("s3cret")
+	orders := &models.Client{ClientID: "orders", SecretHash: secretHash, Scopes: "payments:read", GrantTypes: services.TokenExchangeGrantType}
+	env.clientRepo.On("FindByClientID", "payments").Return(&models.Client{ClientID: "payments"}, nil)
+
+	subject, err := utils.GenerateToken(utils.Grant{UserID: 7, FamilyID: "family-1"}, env.keys)
+	assert.NoError(t, err)
+	env.authRepo.On("FetchAuth", subject.AccessUuid).Return("", errors.New("redis: nil"))
+
+	// Execute
+	_, err = service.Token(orders, services.TokenRequest{
+		GrantType:        services.TokenExchangeGrantType,
+		SubjectToken:     subject.AccessToken,
+		SubjectTokenType: services.AccessTokenType,
+		Audience:         "payments",
+	}, services.ClientInfo{})
+
+	// Assert
+	var oerr *services.OAuthError
+	assert.ErrorAs(t, err, &oerr)
+	assert.Equal(t, "invalid_grant", oerr.Code)
+	env.authRepo.AssertNotCalled(t, "CreateDelegatedAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
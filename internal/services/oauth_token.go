@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+)
+
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	DeviceCode   string
+	Scope        string
+
+	// Token exchange (RFC 8693)
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Audience           string
+}
+
+// TokenResponse is the RFC 6749 access token response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+func newTokenResponse(td *utils.TokenDetails, scope string) *TokenResponse {
+	return &TokenResponse{
+		AccessToken:  td.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    td.AtExpires - time.Now().Unix(),
+		RefreshToken: td.RefreshToken,
+		Scope:        scope,
+	}
+}
+
+// Token handles the token endpoint for an identified client.
+func (s *OAuthService) Token(client *models.Client, req TokenRequest, device ClientInfo) (*TokenResponse, error) {
+	var grant func(*models.Client, TokenRequest, ClientInfo) (*TokenResponse, error)
+	switch req.GrantType {
+	case "authorization_code":
+		grant = s.authorizationCodeGrant
+	case "refresh_token":
+		grant = s.refreshTokenGrant
+	case "client_credentials":
+		grant = s.clientCredentialsGrant
+	case DeviceCodeGrantType:
+		grant = s.deviceCodeGrant
+	case TokenExchangeGrantType:
+		grant = s.tokenExchangeGrant
+	default:
+		return nil, oauthError("unsupported_grant_type", "")
+	}
+
+	if !client.AllowsGrantType(req.GrantType) {
+		return nil, ErrUnauthorizedClient
+	}
+	return grant(client, req, device)
+}
+
+func (s *OAuthService) authorizationCodeGrant(client *models.Client, req TokenRequest, device ClientInfo) (*TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, oauthError("invalid_request", "code and code_verifier are required")
+	}
+
+	authCode, err := s.oauthRepo.ConsumeAuthCode(req.Code)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "Invalid or expired authorization code")
+	}
+	if authCode.ClientID != client.ClientID || authCode.RedirectURI != req.RedirectURI {
+		return nil, oauthError("invalid_grant", "Authorization code was issued to another client or redirect_uri")
+	}
+	if !verifyCodeChallenge(req.CodeVerifier, authCode.CodeChallenge) {
+		return nil, oauthError("invalid_grant", "Invalid code_verifier")
+	}
+	// The ring may have been switched back to HS256 since the code was issued
+	if hasScope(authCode.Scope, "openid") && !s.SupportsOpenID() {
+		return nil, errOpenIDUnavailable
+	}
+
+	td, err := s.authService.StartSession(utils.Grant{
+		UserID:   authCode.UserID,
+		ClientID: client.ClientID,
+		Scope:    authCode.Scope,
+		AMR:      authCode.AMR,
+		AuthTime: authCode.AuthTime,
+	}, device)
+	if err != nil {
+		return nil, err
+	}
+	resp := newTokenResponse(td, authCode.Scope)
+
+	if hasScope(authCode.Scope, "openid") {
+		resp.IDToken, err = s.generateIDToken(client.ClientID, authCode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (s *OAuthService) refreshTokenGrant(client *models.Client, req TokenRequest, device ClientInfo) (*TokenResponse, error) {
+	claims, err := s.keys.Refresh.Parse(req.RefreshToken)
+	if err != nil {
+		return nil, oauthError("invalid_grant", "Invalid refresh token")
+	}
+
+	td, err := s.authService.refresh(req.RefreshToken, client.ClientID, device)
+	if errors.Is(err, ErrRefreshTokenClient) {
+		return nil, ErrRefreshTokenClient
+	}
+	if err != nil {
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+	scope, _ := claims["scope"].(string)
+	return newTokenResponse(td, scope), nil
+}
+
+// clientCredentialsGrant issues a token to the client itself. Without a scope
+// parameter the client gets every scope it is registered for.
+func (s *OAuthService) clientCredentialsGrant(client *models.Client, req TokenRequest, _ ClientInfo) (*TokenResponse, error) {
+	if !client.IsConfidential() {
+		return nil, ErrUnauthorizedClient
+	}
+
+	scope := req.Scope
+	if scope == "" {
+		scope = client.Scopes
+	}
+	if err := validateScope(client, scope); err != nil {
+		return nil, err
+	}
+
+	td, err := utils.GenerateClientToken(client.ClientID, scope, s.keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authRepo.CreateClientAuth(client.ClientID, td.AccessUuid, td.AtExpires); err != nil {
+		return nil, err
+	}
+	return newTokenResponse(td, scope), nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"auth-service/internal/breach"
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/utils"
+)
+
+// Rules reported in PasswordViolation.
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxLength = "max_length"
+	PasswordRuleClasses   = "character_classes"
+	PasswordRuleEmail     = "contains_email"
+	PasswordRuleName      = "contains_name"
+	PasswordRuleReused    = "reused"
+	PasswordRuleBreached  = "breached"
+)
+
+// Parts of the email or name shorter than this are too common to refuse.
+const minPersonalSubstring = 3
+
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a new password breaks.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "password rejected: " + strings.Join(messages, "; ")
+}
+
+// PasswordPolicy decides whether a user may choose a password. The breached
+// password check is skipped when corpus is nil.
+type PasswordPolicy struct {
+	userRepo repository.UserRepository
+	corpus   breach.Corpus
+	cfg      *config.Config
+}
+
+func NewPasswordPolicy(userRepo repository.UserRepository, corpus breach.Corpus, cfg *config.Config) *PasswordPolicy {
+	return &PasswordPolicy{userRepo: userRepo, corpus: corpus, cfg: cfg}
+}
+
+// Check returns a *PasswordPolicyError when password breaks a rule for user.
+// A user without ID is being registered and has no password history.
+func (p *PasswordPolicy) Check(password string, user *models.User) error {
+	var violations []PasswordViolation
+	add := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.cfg.PasswordMinLength {
+		add(PasswordRuleMinLength, "must be at least %d characters", p.cfg.PasswordMinLength)
+	}
+	if p.cfg.PasswordMaxLength > 0 && length > p.cfg.PasswordMaxLength {
+		add(PasswordRuleMaxLength, "must be at most %d characters", p.cfg.PasswordMaxLength)
+	}
+	if characterClasses(password) < p.cfg.PasswordMinClasses {
+		add(PasswordRuleClasses, "must mix at least %d of lower case, upper case, digits and symbols", p.cfg.PasswordMinClasses)
+	}
+
+	lower := strings.ToLower(password)
+	local, _, _ := strings.Cut(strings.ToLower(user.Email), "@")
+	if len(local) >= minPersonalSubstring && strings.Contains(lower, local) {
+		add(PasswordRuleEmail, "must not contain your email address")
+	}
+	for _, part := range strings.Fields(strings.ToLower(user.Name)) {
+		if utf8.RuneCountInString(part) >= minPersonalSubstring && strings.Contains(lower, part) {
+			add(PasswordRuleName, "must not contain your name")
+			break
+		}
+	}
+
+	reused, err := p.reused(password, user)
+	if err != nil {
+		return err
+	}
+	if reused {
+		add(PasswordRuleReused, "must not be one of your last %d passwords", p.cfg.PasswordHistory)
+	}
+
+	if p.corpus != nil {
+		breached, err := p.corpus.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			add(PasswordRuleBreached, "appeared in a data breach, choose another one")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// reused compares the password with the current one and the history.
+func (p *PasswordPolicy) reused(password string, user *models.User) (bool, error) {
+	if user.ID == 0 || p.cfg.PasswordHistory <= 0 {
+		return false, nil
+	}
+
+	hashes := []string{user.Password}
+	if p.cfg.PasswordHistory > 1 {
+		history, err := p.userRepo.ListPasswordHistory(user.ID, p.cfg.PasswordHistory-1)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, history...)
+	}
+	for _, hash := range hashes {
+		if match, _ := utils.VerifyPassword(password, hash); match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// historyToKeep is how many replaced hashes the repository must keep for
+// the reuse check, besides the current one.
+func (p *PasswordPolicy) historyToKeep() int {
+	return max(p.cfg.PasswordHistory-1, 0)
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
@@ -0,0 +1,117 @@
+package services_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"auth-service/internal/breach"
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/repository/mocks"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var policyConfig = &config.Config{
+	PasswordMinLength:  10,
+	PasswordMaxLength:  64,
+	PasswordMinClasses: 2,
+	PasswordHistory:    3,
+}
+
+func violatedRules(t *testing.T, err error) []string {
+	var policyErr *services.PasswordPolicyError
+	if !assert.ErrorAs(t, err, &policyErr) {
+		return nil
+	}
+	rules := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		assert.NotEmpty(t, v.Message)
+		rules[i] = v.Rule
+	}
+	return rules
+}
+
+func TestPasswordPolicy_Rules(t *testing.T) {
+	policy := services.NewPasswordPolicy(new(mocks.MockUserRepository), nil, policyConfig)
+	user := &models.User{Name: "John Smith", Email: "jdoe87@example.com"}
+
+	tests := []struct {
+		password string
+		rules    []string
+	}{
+		{"correct horse battery", nil},
+		{"Tr0ub4dor&3", nil},
+		{"short1", []string{services.PasswordRuleMinLength}},
+		{strings.Repeat("ab1", 22), []string{services.PasswordRuleMaxLength}},
+		{"onlylowercaseletters", []string{services.PasswordRuleClasses}},
+		{"my-JDOE87-password", []string{services.PasswordRuleEmail}},
+		{"hello smith 2024", []string{services.PasswordRuleName}},
+		{"john", []string{services.PasswordRuleMinLength, services.PasswordRuleClasses, services.PasswordRuleName}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			err := policy.Check(tt.password, user)
+			if tt.rules == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.rules, violatedRules(t, err))
+		})
+	}
+}
+
+func TestPasswordPolicy_History(t *testing.T) {
+	userRepo := new(mocks.MockUserRepository)
+	policy := services.NewPasswordPolicy(userRepo, nil, policyConfig)
+
+	current, _ := utils.HashPassword("current password 1")
+	previous, _ := utils.HashPassword("previous password 1")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: current}
+	userRepo.On("ListPasswordHistory", user.ID, 2).Return([]string{previous}, nil)
+
+	assert.Equal(t, []string{services.PasswordRuleReused}, violatedRules(t, policy.Check("current password 1", user)))
+	assert.Equal(t, []string{services.PasswordRuleReused}, violatedRules(t, policy.Check("previous password 1", user)))
+	assert.NoError(t, policy.Check("a brand new password 1", user))
+
+	// New users have no history to check
+	assert.NoError(t, policy.Check("current password 1", &models.User{Email: "jane@example.com"}))
+}
+
+func TestPasswordPolicy_BreachedCorpus(t *testing.T) {
+	// A range file in the Pwned Passwords format
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("Password123!"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + strings.ToLower(hash[5:]) + ":120375\r\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0o644))
+
+	corpus, err := breach.NewDirCorpus(dir)
+	assert.NoError(t, err)
+	policy := services.NewPasswordPolicy(new(mocks.MockUserRepository), corpus, policyConfig)
+	user := &models.User{Email: "john@example.com"}
+
+	assert.Equal(t, []string{services.PasswordRuleBreached}, violatedRules(t, policy.Check("Password123!", user)))
+	// Same prefix file missing, or suffix not listed
+	assert.NoError(t, policy.Check("Password1234!", user))
+
+	_, err = breach.NewDirCorpus(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestRegister_PasswordPolicy(t *testing.T) {
+	userRepo := new(mocks.MockUserRepository)
+	policy := services.NewPasswordPolicy(userRepo, nil, policyConfig)
+	service := services.NewAuthService(userRepo, new(mocks.MockAuthRepository), new(mocks.MockMFARepository), new(mocks.MockChallengeRepository), policy, nil, nil, policyConfig)
+	userRepo.On("FindByEmail", "john@example.com").Return(nil, nil)
+
+	err := service.Register("John", "john@example.com", "john")
+	assert.Equal(t, []string{services.PasswordRuleMinLength, services.PasswordRuleClasses, services.PasswordRuleEmail, services.PasswordRuleName}, violatedRules(t, err))
+	userRepo.AssertNotCalled(t, "CreateUser")
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"auth-service/internal/config"
+	"auth-service/internal/events"
+	"auth-service/internal/mailer"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// PasswordService manages the passwords of existing accounts.
+type PasswordService struct {
+	userRepo      repository.UserRepository
+	challengeRepo repository.ChallengeRepository
+	authService   *AuthService
+	mailer        mailer.Mailer
+	events        events.Publisher
+	cfg           *config.Config
+}
+
+func NewPasswordService(userRepo repository.UserRepository, challengeRepo repository.ChallengeRepository, authService *AuthService, mailer mailer.Mailer, events events.Publisher, cfg *config.Config) *PasswordService {
+	return &PasswordService{
+		userRepo:      userRepo,
+		challengeRepo: challengeRepo,
+		authService:   authService,
+		mailer:        mailer,
+		events:        events,
+		cfg:           cfg,
+	}
+}
+
+// A pending reset is an OTP challenge with only a link token, so it gets the
+// same single use and attempt limit as magic links.
+func resetDestination(email string) string {
+	return "reset:" + models.NormalizeEmail(email)
+}
+
+// ForgotPassword mails a reset link. Unknown addresses, repeated requests
+// within a minute and failed mails are silently ignored, so callers cannot
+// tell them apart.
+func (s *PasswordService) ForgotPassword(email string, client ClientInfo) error {
+	destination := resetDestination(email)
+	allowed, err := s.challengeRepo.ThrottleOTP(destination, otpResendInterval)
+	if err != nil || !allowed {
+		return err
+	}
+
+	user, err := s.userRepo.FindByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateSecret(32)
+	if err != nil {
+		return err
+	}
+	err = s.challengeRepo.SaveOTPChallenge(destination, &models.OTPChallenge{
+		UserID:    user.ID,
+		LinkHash:  hashOTP(token),
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		CreatedAt: time.Now(),
+	}, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?%s", s.cfg.PasswordResetURL, url.Values{"email": {user.Email}, "token": {token}}.Encode())
+	err = s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Choose a new password with this link. It expires in %d minutes and works once:\n%s\n\n"+
+			"If you did not ask to reset your password, you can ignore this email.\n",
+			int(passwordResetTTL.Minutes()), link),
+	})
+	if err != nil {
+		// An error would tell the caller the address has an account
+		log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+		s.challengeRepo.DeleteOTPChallenge(destination)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with the token from the reset link and
+// logs the user out everywhere, since the old password may be known to
+// someone else. Trusted devices ask for the second factor again. A password
+// rejected by the policy leaves the token usable.
+func (s *PasswordService) ResetPassword(email, token, password string) error {
+	destination := resetDestination(email)
+	challenge, err := checkOTP(s.challengeRepo, destination, func(c *models.OTPChallenge) string { return c.LinkHash }, token)
+	if errors.Is(err, ErrInvalidOTP) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.FindByID(challenge.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.authService.policy.Check(password, user); err != nil {
+		return err
+	}
+	err = deleteOTP(s.challengeRepo, destination)
+	if errors.Is(err, ErrInvalidOTP) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(user.ID, hashed, s.authService.policy.historyToKeep()); err != nil {
+		return err
+	}
+	if err := s.authService.LogoutAll(challenge.UserID); err != nil {
+		return err
+	}
+	if err := s.authService.forgetTrustedDevices(challenge.UserID); err != nil {
+		return err
+	}
+
+	s.events.Publish(events.New(events.PasswordReset, challenge.UserID, nil))
+	return nil
+}
+
+// ChangePassword replaces the password of a logged in user after checking
+// the current one. Other sessions are logged out; the current one stays.
+func (s *PasswordService) ChangePassword(userID uint, familyID, current, password string, client ClientInfo) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if !s.authService.checkPassword(user, current) {
+		return ErrInvalidCredentials
+	}
+	if err := s.authService.policy.Check(password, user); err != nil {
+		return err
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(userID, hashed, s.authService.policy.historyToKeep()); err != nil {
+		return err
+	}
+	if err := s.authService.LogoutOthers(userID, familyID); err != nil {
+		return err
+	}
+
+	s.events.Publish(events.New(events.PasswordChanged, userID, map[string]interface{}{
+		"ip":         client.IP,
+		"user_agent": client.UserAgent,
+	}))
+	return nil
+}
@@ -0,0 +1,162 @@
+package services_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"auth-service/internal/config"
+	"auth-service/internal/events"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// passwordConfig sets the reset link and a password policy with history.
+func passwordConfig() *config.Config {
+	return &config.Config{
+		PasswordResetURL:  "https://app.example.com/reset-password",
+		PasswordMinLength: 10,
+		PasswordHistory:   3,
+	}
+}
+
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordConfig())
+	service := env.passwordService()
+	env.challengeRepo.On("ThrottleOTP", "reset:nobody@example.com", mock.Anything).Return(true, nil)
+	env.userRepo.On("FindByEmail", "nobody@example.com").Return(nil, gorm.ErrRecordNotFound)
+
+	// Execute: same answer as for a known address, without a mail
+	assert.NoError(t, service.ForgotPassword("nobody@example.com", services.ClientInfo{}))
+
+	// Assert
+	assert.Nil(t, env.mailer.Last())
+	env.challengeRepo.AssertNotCalled(t, "SaveOTPChallenge", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestForgotPassword_MailFailure(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordConfig())
+	service := env.passwordService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "reset:john@example.com"
+
+	env.mailer.Err = errors.New("smtp: connection refused")
+	env.challengeRepo.On("ThrottleOTP", destination, mock.Anything).Return(true, nil)
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.challengeRepo.On("SaveOTPChallenge", destination, mock.Anything, mock.Anything).Return(nil)
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil)
+
+	// Execute
+	err := service.ForgotPassword(user.Email, services.ClientInfo{})
+
+	// Assert: same answer as for an unknown address, and the link is dropped
+	assert.NoError(t, err)
+	env.challengeRepo.AssertCalled(t, "DeleteOTPChallenge", destination)
+}
+
+func TestResetPassword(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordConfig())
+	service := env.passwordService()
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+	destination := "reset:john@example.com"
+
+	challenge := &models.OTPChallenge{}
+	env.challengeRepo.On("ThrottleOTP", destination, mock.Anything).Return(true, nil).Once()
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.challengeRepo.On("SaveOTPChallenge", destination, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*challenge = *args.Get(1).(*models.OTPChallenge)
+	}).Return(nil).Once()
+
+	// Execute & Assert
+	assert.NoError(t, service.ForgotPassword(user.Email, services.ClientInfo{}))
+	msg := env.mailer.Last()
+	assert.NotNil(t, msg)
+	assert.Contains(t, msg.Body, "https://app.example.com/reset-password?email=")
+	token := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	assert.Len(t, token, 2)
+
+	// Only the hash of the token is stored
+	assert.NotEmpty(t, challenge.LinkHash)
+	assert.NotContains(t, challenge.LinkHash, token[1])
+
+	// A second request within a minute sends nothing
+	env.challengeRepo.On("ThrottleOTP", destination, mock.Anything).Return(false, nil).Once()
+	assert.NoError(t, service.ForgotPassword(user.Email, services.ClientInfo{}))
+	env.challengeRepo.AssertNumberOfCalls(t, "SaveOTPChallenge", 1)
+
+	env.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	env.expectOTPFailures(destination)
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(1), nil)
+	env.challengeRepo.On("ForgiveOTPAttempt", destination).Return(nil)
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.userRepo.On("ListPasswordHistory", user.ID, 2).Return(nil, nil)
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil).Once()
+	env.userRepo.On("UpdatePassword", user.ID, mock.MatchedBy(func(hash string) bool {
+		match, _ := utils.VerifyPassword("new-password", hash)
+		return match
+	}), 2).Return(nil)
+	env.authRepo.On("RevokeUserFamilies", user.ID).Return(nil)
+	env.mfaRepo.On("DeleteTrustedDevices", user.ID).Return(nil)
+
+	assert.ErrorIs(t, service.ResetPassword(user.Email, "wrong", "new-password"), services.ErrInvalidResetToken)
+	env.challengeRepo.AssertNotCalled(t, "ForgiveOTPAttempt", destination)
+
+	// A password the policy rejects keeps the token usable, and the attempt
+	// is not counted against it
+	var policyErr *services.PasswordPolicyError
+	assert.ErrorAs(t, service.ResetPassword(user.Email, token[1], "password123"), &policyErr)
+	assert.Equal(t, services.PasswordRuleReused, policyErr.Violations[0].Rule)
+	env.challengeRepo.AssertNotCalled(t, "DeleteOTPChallenge", destination)
+	env.challengeRepo.AssertNumberOfCalls(t, "ForgiveOTPAttempt", 1)
+
+	assert.NoError(t, service.ResetPassword(user.Email, token[1], "new-password"))
+	env.authRepo.AssertExpectations(t)
+	// Remembered devices ask for the second factor again
+	env.mfaRepo.AssertCalled(t, "DeleteTrustedDevices", user.ID)
+	assert.Equal(t, []string{events.PasswordReset}, env.events.Types())
+
+	// The token works once
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(false, nil)
+	assert.ErrorIs(t, service.ResetPassword(user.Email, token[1], "other-password"), services.ErrInvalidResetToken)
+}
+
+func TestChangePassword(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordConfig())
+	service := env.passwordService()
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+
+	// Execute & Assert
+	err := service.ChangePassword(user.ID, "family-1", "wrong", "new-password", services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrInvalidCredentials)
+	env.userRepo.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything)
+
+	env.userRepo.On("ListPasswordHistory", user.ID, 2).Return(nil, nil)
+	env.userRepo.On("UpdatePassword", user.ID, mock.MatchedBy(func(hash string) bool {
+		match, _ := utils.VerifyPassword("new-password", hash)
+		return match
+	}), 2).Return(nil)
+	env.authRepo.On("ListSessions", user.ID).Return([]models.Session{{ID: "family-1"}, {ID: "family-2"}, {ID: "family-3"}}, nil)
+	env.authRepo.On("RevokeFamily", "family-2").Return(nil)
+	env.authRepo.On("RevokeFamily", "family-3").Return(nil)
+
+	err = service.ChangePassword(user.ID, "family-1", "password123", "new-password", services.ClientInfo{IP: "203.0.113.7"})
+	assert.NoError(t, err)
+	// The current session stays logged in
+	env.authRepo.AssertNotCalled(t, "RevokeFamily", "family-1")
+	env.authRepo.AssertExpectations(t)
+
+	assert.Equal(t, []string{events.PasswordChanged}, env.events.Types())
+	assert.Equal(t, "203.0.113.7", env.events.Events[0].Data["ip"])
+}
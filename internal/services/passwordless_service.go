@@ -0,0 +1,253 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"auth-service/internal/config"
+	"auth-service/internal/mailer"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	otpDigits         = 6
+	otpTTL            = 10 * time.Minute
+	otpResendInterval = time.Minute
+	maxOTPAttempts    = 5
+
+	// Wrong codes count per destination across the codes sent to it, so
+	// asking for a new code does not reset them. After maxOTPFailures within
+	// otpFailureWindow the destination is locked until the window ends.
+	maxOTPFailures   = 10
+	otpFailureWindow = time.Hour
+)
+
+var (
+	ErrInvalidOTP   = errors.New("invalid or expired code")
+	ErrOTPThrottled = errors.New("a code was sent recently, please wait before requesting another")
+	ErrOTPLocked    = errors.New("too many invalid codes, please try again later")
+)
+
+// PasswordlessService logs users in with one-time codes sent out of band.
+// Logins end in the same MFA check and token issuance as AuthService.Login.
+type PasswordlessService struct {
+	userRepo      repository.UserRepository
+	challengeRepo repository.ChallengeRepository
+	authService   *AuthService
+	mailer        mailer.Mailer
+	cfg           *config.Config
+}
+
+func NewPasswordlessService(userRepo repository.UserRepository, challengeRepo repository.ChallengeRepository, authService *AuthService, mailer mailer.Mailer, cfg *config.Config) *PasswordlessService {
+	return &PasswordlessService{
+		userRepo:      userRepo,
+		challengeRepo: challengeRepo,
+		authService:   authService,
+		mailer:        mailer,
+		cfg:           cfg,
+	}
+}
+
+func emailDestination(email string) string {
+	return "email:" + models.NormalizeEmail(email)
+}
+
+func hashOTP(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// StartEmailLogin mails a sign-in code and magic link. Unknown addresses and
+// failed mails get the same answer, so the endpoint cannot be used to
+// discover accounts.
+func (s *PasswordlessService) StartEmailLogin(email string, client ClientInfo) error {
+	destination := emailDestination(email)
+	allowed, err := s.challengeRepo.ThrottleOTP(destination, otpResendInterval)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrOTPThrottled
+	}
+
+	user, err := s.userRepo.FindByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	code, err := utils.GenerateNumericCode(otpDigits)
+	if err != nil {
+		return err
+	}
+	linkToken, err := utils.GenerateSecret(32)
+	if err != nil {
+		return err
+	}
+
+	err = s.challengeRepo.SaveOTPChallenge(destination, &models.OTPChallenge{
+		UserID:     user.ID,
+		CodeHash:   hashOTP(code),
+		LinkHash:   hashOTP(linkToken),
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		DeviceName: client.DeviceName,
+		CreatedAt:  time.Now(),
+	}, otpTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?%s", s.cfg.MagicLinkURL, url.Values{"email": {user.Email}, "token": {linkToken}}.Encode())
+	err = s.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in code",
+		Body: fmt.Sprintf("Your sign-in code is %s. It expires in %d minutes.\n\n"+
+			"Or sign in with this link:\n%s\n\n"+
+			"If you did not try to sign in, you can ignore this email.\n",
+			code, int(otpTTL.Minutes()), link),
+	})
+	if err != nil {
+		// An error would tell the caller the address has an account
+		log.Printf("Failed to send sign-in email to user %d: %v", user.ID, err)
+		s.challengeRepo.DeleteOTPChallenge(destination)
+	}
+	return nil
+}
+
+// VerifyEmailCode completes an email login with the 6-digit code.
+func (s *PasswordlessService) VerifyEmailCode(email, code string, client ClientInfo) (*utils.TokenDetails, error) {
+	return s.verify(emailDestination(email), otpCode, code, client)
+}
+
+// VerifyMagicLink completes an email login with the token from the link.
+func (s *PasswordlessService) VerifyMagicLink(email, token string, client ClientInfo) (*utils.TokenDetails, error) {
+	return s.verify(emailDestination(email), func(c *models.OTPChallenge) string { return c.LinkHash }, token, client)
+}
+
+func (s *PasswordlessService) verify(destination string, expected func(*models.OTPChallenge) string, secret string, client ClientInfo) (*utils.TokenDetails, error) {
+	challenge, err := consumeOTP(s.challengeRepo, destination, expected, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	amr := []string{utils.AMROTP}
+	if err := s.authService.requireMFA(user, client, amr); err != nil {
+		return nil, err
+	}
+	return s.authService.StartSession(utils.Grant{UserID: user.ID, AMR: amr}, client)
+}
+
+// consumeOTP checks secret against the hash selected from the pending
+// challenge and deletes the challenge when it matches. The secrets of a
+// challenge share its attempt limit, and any one of them uses it up.
+func consumeOTP(challengeRepo repository.ChallengeRepository, destination string, expected func(*models.OTPChallenge) string, secret string) (*models.OTPChallenge, error) {
+	challenge, err := matchOTP(challengeRepo, destination, expected, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteOTP(challengeRepo, destination); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// checkOTP is consumeOTP without using up the challenge, for callers that
+// still need to validate the rest of the request. Only a wrong secret counts
+// as an attempt, so a request rejected for another reason can be retried.
+func checkOTP(challengeRepo repository.ChallengeRepository, destination string, expected func(*models.OTPChallenge) string, secret string) (*models.OTPChallenge, error) {
+	challenge, err := matchOTP(challengeRepo, destination, expected, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := challengeRepo.ForgiveOTPAttempt(destination); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// matchOTP counts an attempt before comparing, so concurrent guesses cannot
+// get past the limit. Every failure also counts against the destination,
+// whether or not a code is pending, so the lockout does not reveal which
+// addresses have an account.
+func matchOTP(challengeRepo repository.ChallengeRepository, destination string, expected func(*models.OTPChallenge) string, secret string) (*models.OTPChallenge, error) {
+	failures, err := challengeRepo.CountOTPFailures(destination)
+	if err != nil {
+		return nil, err
+	}
+	if failures >= maxOTPFailures {
+		return nil, ErrOTPLocked
+	}
+
+	challenge, err := compareOTP(challengeRepo, destination, expected, secret)
+	if errors.Is(err, ErrInvalidOTP) {
+		if _, err := challengeRepo.RecordOTPFailure(destination, otpFailureWindow); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func compareOTP(challengeRepo repository.ChallengeRepository, destination string, expected func(*models.OTPChallenge) string, secret string) (*models.OTPChallenge, error) {
+	challenge, err := challengeRepo.FetchOTPChallenge(destination)
+	if err != nil {
+		return nil, ErrInvalidOTP
+	}
+
+	attempts, err := challengeRepo.RecordOTPAttempt(destination)
+	if err != nil {
+		return nil, err
+	}
+	if attempts == 0 {
+		// Expired since it was fetched
+		return nil, ErrInvalidOTP
+	}
+	if attempts > maxOTPAttempts {
+		challengeRepo.DeleteOTPChallenge(destination)
+		return nil, ErrInvalidOTP
+	}
+
+	want := expected(challenge)
+	if secret == "" || want == "" || subtle.ConstantTimeCompare([]byte(hashOTP(secret)), []byte(want)) != 1 {
+		return nil, ErrInvalidOTP
+	}
+	return challenge, nil
+}
+
+// deleteOTP uses up a checked challenge and clears the failures of the
+// destination. Only one caller can win.
+func deleteOTP(challengeRepo repository.ChallengeRepository, destination string) error {
+	deleted, err := challengeRepo.DeleteOTPChallenge(destination)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		// Completed concurrently
+		return ErrInvalidOTP
+	}
+	return challengeRepo.ResetOTPFailures(destination)
+}
+
+// otpCode selects the one-time code of a challenge.
+func otpCode(c *models.OTPChallenge) string {
+	return c.CodeHash
+}
@@ -0,0 +1,236 @@
+package services_test
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// passwordlessConfig sets the link mailed with email login codes.
+func passwordlessConfig() *config.Config {
+	return &config.Config{MagicLinkURL: "https://app.example.com/login/email"}
+}
+
+// startEmailLogin runs StartEmailLogin and returns the stored challenge
+// together with the code and link token from the mail.
+func (e *testEnv) startEmailLogin(t *testing.T, user *models.User) (*models.OTPChallenge, string, string) {
+	destination := "email:" + user.Email
+	challenge := &models.OTPChallenge{}
+	e.challengeRepo.On("ThrottleOTP", destination, mock.Anything).Return(true, nil).Once()
+	e.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	e.challengeRepo.On("SaveOTPChallenge", destination, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*challenge = *args.Get(1).(*models.OTPChallenge)
+	}).Return(nil).Once()
+
+	err := e.passwordlessService().StartEmailLogin(user.Email, services.ClientInfo{DeviceName: "Pixel 8"})
+	assert.NoError(t, err)
+
+	msg := e.mailer.Last()
+	assert.NotNil(t, msg)
+	assert.Equal(t, user.Email, msg.To)
+	code := regexp.MustCompile(`code is (\d{6})`).FindStringSubmatch(msg.Body)
+	token := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg.Body)
+	assert.Len(t, code, 2)
+	assert.Len(t, token, 2)
+	assert.Contains(t, msg.Body, "https://app.example.com/login/email?email=")
+	return challenge, code[1], token[1]
+}
+
+// expectOTPFailures lets wrong codes for destination be counted without
+// reaching the lockout.
+func (e *testEnv) expectOTPFailures(destination string) {
+	e.challengeRepo.On("CountOTPFailures", destination).Return(int64(0), nil)
+	e.challengeRepo.On("RecordOTPFailure", destination, mock.Anything).Return(int64(1), nil)
+	e.challengeRepo.On("ResetOTPFailures", destination).Return(nil)
+}
+
+func (e *testEnv) expectSession(user *models.User) {
+	e.userRepo.On("FindByID", user.ID).Return(user, nil)
+	e.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	e.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil)
+	e.authRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	e.authRepo.On("SaveSession", mock.Anything).Return(nil)
+}
+
+func TestEmailLogin_Code(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "email:" + user.Email
+
+	challenge, code, _ := env.startEmailLogin(t, user)
+	assert.Equal(t, user.ID, challenge.UserID)
+	assert.NotContains(t, challenge.CodeHash, code)
+
+	env.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	env.expectOTPFailures(destination)
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(1), nil)
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil)
+	env.expectSession(user)
+
+	// Execute & Assert
+	n, _ := strconv.Atoi(code)
+	_, err := service.VerifyEmailCode(user.Email, fmt.Sprintf("%06d", (n+1)%1000000), services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrInvalidOTP)
+
+	// Addresses are matched case-insensitively
+	token, err := service.VerifyEmailCode("John@Example.com", code, services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+}
+
+func TestEmailLogin_MagicLinkAndAttemptLimit(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "email:" + user.Email
+
+	challenge, _, linkToken := env.startEmailLogin(t, user)
+	env.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	env.expectOTPFailures(destination)
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(1), nil).Once()
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil)
+	env.expectSession(user)
+
+	// Execute & Assert
+	token, err := service.VerifyMagicLink(user.Email, linkToken, services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+
+	// The sixth attempt drops the challenge even with the right token
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(6), nil)
+	_, err = service.VerifyMagicLink(user.Email, linkToken, services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrInvalidOTP)
+	env.authRepo.AssertNumberOfCalls(t, "CreateAuth", 1)
+}
+
+func TestEmailLogin_LockoutAcrossCodes(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "email:" + user.Email
+
+	challenge, code, _ := env.startEmailLogin(t, user)
+	env.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(1), nil)
+	env.challengeRepo.On("CountOTPFailures", destination).Return(int64(9), nil).Once()
+	env.challengeRepo.On("RecordOTPFailure", destination, mock.Anything).Return(int64(10), nil).Once()
+
+	// Execute & Assert: wrong codes count across re-sent codes
+	n, _ := strconv.Atoi(code)
+	_, err := service.VerifyEmailCode(user.Email, fmt.Sprintf("%06d", (n+1)%1000000), services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrInvalidOTP)
+
+	// Once locked, even the right code is refused
+	env.challengeRepo.On("CountOTPFailures", destination).Return(int64(10), nil)
+	_, err = service.VerifyEmailCode(user.Email, code, services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrOTPLocked)
+	env.challengeRepo.AssertNumberOfCalls(t, "RecordOTPAttempt", 1)
+
+	// Addresses without a pending code count too, so the lockout does not
+	// tell which have an account
+	env.challengeRepo.On("CountOTPFailures", "email:nobody@example.com").Return(int64(0), nil)
+	env.challengeRepo.On("FetchOTPChallenge", "email:nobody@example.com").Return(nil, errors.New("redis: nil"))
+	env.challengeRepo.On("RecordOTPFailure", "email:nobody@example.com", mock.Anything).Return(int64(1), nil)
+	_, err = service.VerifyEmailCode("nobody@example.com", code, services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrInvalidOTP)
+	env.challengeRepo.AssertCalled(t, "RecordOTPFailure", "email:nobody@example.com", mock.Anything)
+}
+
+func TestEmailLogin_ExpiredDuringVerify(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "email:" + user.Email
+
+	challenge, code, _ := env.startEmailLogin(t, user)
+	env.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	env.expectOTPFailures(destination)
+	// The challenge expired between the fetch and the attempt
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(0), nil)
+
+	// Execute
+	_, err := service.VerifyEmailCode(user.Email, code, services.ClientInfo{})
+
+	// Assert
+	assert.ErrorIs(t, err, services.ErrInvalidOTP)
+	env.challengeRepo.AssertNotCalled(t, "DeleteOTPChallenge", destination)
+}
+
+func TestEmailLogin_UnknownAddressAndThrottle(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+
+	env.challengeRepo.On("ThrottleOTP", "email:nobody@example.com", mock.Anything).Return(true, nil).Once()
+	env.userRepo.On("FindByEmail", "nobody@example.com").Return(nil, gorm.ErrRecordNotFound)
+
+	// Execute & Assert: unknown addresses get no mail but the same answer
+	assert.NoError(t, service.StartEmailLogin("nobody@example.com", services.ClientInfo{}))
+	assert.Nil(t, env.mailer.Last())
+
+	env.challengeRepo.On("ThrottleOTP", "email:nobody@example.com", mock.Anything).Return(false, nil)
+	err := service.StartEmailLogin("nobody@example.com", services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrOTPThrottled)
+}
+
+func TestEmailLogin_MailFailure(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "email:" + user.Email
+
+	env.mailer.Err = errors.New("smtp: connection refused")
+	env.challengeRepo.On("ThrottleOTP", destination, mock.Anything).Return(true, nil)
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.challengeRepo.On("SaveOTPChallenge", destination, mock.Anything, mock.Anything).Return(nil)
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil)
+
+	// Execute
+	err := service.StartEmailLogin(user.Email, services.ClientInfo{})
+
+	// Assert: same answer as for an unknown address, and the code is dropped
+	assert.NoError(t, err)
+	env.challengeRepo.AssertCalled(t, "DeleteOTPChallenge", destination)
+}
+
+func TestEmailLogin_RequiresMFA(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, passwordlessConfig())
+	service := env.passwordlessService()
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	destination := "email:" + user.Email
+
+	challenge, code, _ := env.startEmailLogin(t, user)
+	env.challengeRepo.On("FetchOTPChallenge", destination).Return(challenge, nil)
+	env.expectOTPFailures(destination)
+	env.challengeRepo.On("RecordOTPAttempt", destination).Return(int64(1), nil)
+	env.challengeRepo.On("DeleteOTPChallenge", destination).Return(true, nil)
+	env.userRepo.On("FindByID", user.ID).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return([]models.WebAuthnCredential{{UserID: user.ID}}, nil)
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// Execute
+	_, err := service.VerifyEmailCode(user.Email, code, services.ClientInfo{})
+
+	// Assert
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+	env.authRepo.AssertNotCalled(t, "CreateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
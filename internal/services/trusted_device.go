@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"auth-service/internal/models"
+	"auth-service/internal/utils"
+)
+
+var ErrTrustedDeviceNotFound = errors.New("trusted device not found")
+
+// trustDevice remembers the device of a completed MFA login and returns the
+// token it presents on later logins. The token is random rather than a JWT
+// so it outlives the rotation of the signing keys.
+func (s *AuthService) trustDevice(userID uint, client ClientInfo) (string, error) {
+	token, err := utils.GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+
+	label := client.DeviceName
+	if label == "" {
+		label = client.UserAgent
+	}
+	err = s.mfaRepo.SaveTrustedDevice(&models.TrustedDevice{
+		UserID:    userID,
+		TokenHash: hashOTP(token),
+		Label:     label,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		ExpiresAt: time.Now().Add(s.cfg.TrustedDeviceTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// deviceTrusted reports whether token belongs to an unexpired trusted device
+// of the user, and records its use.
+func (s *AuthService) deviceTrusted(userID uint, token string) bool {
+	if token == "" {
+		return false
+	}
+	device, err := s.mfaRepo.FindTrustedDevice(hashOTP(token))
+	if err != nil || device.UserID != userID || device.Expired() {
+		return false
+	}
+
+	now := time.Now()
+	device.LastUsedAt = &now
+	s.mfaRepo.SaveTrustedDevice(device)
+	return true
+}
+
+func (s *AuthService) ListTrustedDevices(userID uint) ([]models.TrustedDevice, error) {
+	return s.mfaRepo.ListTrustedDevices(userID)
+}
+
+// RevokeTrustedDevice makes the next login from the device ask for the second
+// factor again.
+func (s *AuthService) RevokeTrustedDevice(userID, id uint) error {
+	deleted, err := s.mfaRepo.DeleteTrustedDevice(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrTrustedDeviceNotFound
+	}
+	return nil
+}
+
+// forgetTrustedDevices makes every device of the user ask for the second
+// factor again.
+func (s *AuthService) forgetTrustedDevices(userID uint) error {
+	return s.mfaRepo.DeleteTrustedDevices(userID)
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth-service/internal/config"
+	"auth-service/internal/models"
+	"auth-service/internal/repository"
+	"auth-service/internal/utils"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const webAuthnSessionTTL = 5 * time.Minute
+
+var (
+	ErrWebAuthnSession    = errors.New("WebAuthn session expired or invalid")
+	ErrWebAuthnFailed     = errors.New("WebAuthn verification failed")
+	ErrCredentialNotFound = errors.New("credential not found")
+)
+
+// webAuthnUser adapts a user and their stored credentials to the library.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+// The user handle is the user ID. It is stored on the authenticator and
+// identifies the account during passwordless login.
+func userHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return userHandle(u.user.ID)
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	creds := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		var transports []protocol.AuthenticatorTransport
+		for _, t := range strings.Fields(c.Transports) {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		creds[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(c.Flags)),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		}
+	}
+	return creds
+}
+
+func (u *webAuthnUser) stored(credentialID []byte) *models.WebAuthnCredential {
+	for i := range u.credentials {
+		if string(u.credentials[i].CredentialID) == string(credentialID) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
+
+type WebAuthnService struct {
+	userRepo      repository.UserRepository
+	mfaRepo       repository.MFARepository
+	challengeRepo repository.ChallengeRepository
+	authService   *AuthService
+	webauthn      *webauthn.WebAuthn
+}
+
+func NewWebAuthnService(userRepo repository.UserRepository, mfaRepo repository.MFARepository, challengeRepo repository.ChallengeRepository, authService *AuthService, cfg *config.Config) (*WebAuthnService, error) {
+	var origins []string
+	for _, origin := range strings.Split(cfg.WebAuthnOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnService{
+		userRepo:      userRepo,
+		mfaRepo:       mfaRepo,
+		challengeRepo: challengeRepo,
+		authService:   authService,
+		webauthn:      w,
+	}, nil
+}
+
+func (s *WebAuthnService) loadUser(userID uint) (*webAuthnUser, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s.mfaRepo.ListWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user, creds}, nil
+}
+
+// BeginRegistration starts adding a passkey to the account. The returned
+// session ID must be sent back with the authenticator's response.
+func (s *WebAuthnService) BeginRegistration(userID uint, name string) (string, *protocol.CredentialCreation, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	options, session, err := s.webauthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sessionID, err := s.saveSession(&models.WebAuthnSession{
+		Purpose: models.WebAuthnRegistration,
+		UserID:  userID,
+		Name:    name,
+		Data:    *session,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, options, nil
+}
+
+// FinishRegistration verifies the attestation and stores the credential.
+func (s *WebAuthnService) FinishRegistration(userID uint, sessionID string, response []byte) (*models.WebAuthnCredential, error) {
+	session, err := s.consumeSession(sessionID, models.WebAuthnRegistration)
+	if err != nil || session.UserID != userID {
+		return nil, ErrWebAuthnSession
+	}
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, ErrWebAuthnFailed
+	}
+	credential, err := s.webauthn.CreateCredential(user, session.Data, parsed)
+	if err != nil {
+		return nil, ErrWebAuthnFailed
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+	cred := &models.WebAuthnCredential{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, " "),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Flags:           uint8(credential.Flags.ProtocolValue()),
+		Name:            session.Name,
+	}
+	if err := s.mfaRepo.SaveWebAuthnCredential(cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (s *WebAuthnService) ListCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	return s.mfaRepo.ListWebAuthnCredentials(userID)
+}
+
+func (s *WebAuthnService) DeleteCredential(userID, id uint) error {
+	deleted, err := s.mfaRepo.DeleteWebAuthnCredential(userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+// BeginLogin starts a passwordless login with a discoverable credential. The
+// authenticator must verify the user, which makes the passkey a complete
+// multi-factor login on its own.
+func (s *WebAuthnService) BeginLogin() (string, *protocol.CredentialAssertion, error) {
+	options, session, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sessionID, err := s.saveSession(&models.WebAuthnSession{
+		Purpose: models.WebAuthnLogin,
+		Data:    *session,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, options, nil
+}
+
+// FinishLogin verifies the assertion and issues the same token pair as a
+// password login.
+func (s *WebAuthnService) FinishLogin(sessionID string, response []byte, client ClientInfo) (*utils.TokenDetails, error) {
+	session, err := s.consumeSession(sessionID, models.WebAuthnLogin)
+	if err != nil {
+		return nil, ErrWebAuthnSession
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, ErrWebAuthnFailed
+	}
+
+	var user *webAuthnUser
+	_, credential, err := s.webauthn.ValidatePasskeyLogin(func(rawID, handle []byte) (webauthn.User, error) {
+		userID, err := strconv.ParseUint(string(handle), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		user, err = s.loadUser(uint(userID))
+		return user, err
+	}, session.Data, parsed)
+	if err != nil {
+		return nil, ErrWebAuthnFailed
+	}
+
+	if err := s.recordUse(user, credential); err != nil {
+		return nil, err
+	}
+	// User verification makes the passkey a second factor on its own
+	amr := []string{utils.AMRHardwareKey, utils.AMRMFA}
+	return s.authService.StartSession(utils.Grant{UserID: user.user.ID, AMR: amr}, client)
+}
+
+// BeginMFA starts an assertion for a login waiting for its second factor. The
+// MFA challenge token doubles as the WebAuthn session ID.
+func (s *WebAuthnService) BeginMFA(challengeToken string) (*protocol.CredentialAssertion, error) {
+	challenge, err := s.challengeRepo.FetchMFAChallenge(challengeToken)
+	if err != nil {
+		return nil, ErrMFAChallenge
+	}
+	user, err := s.loadUser(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.credentials) == 0 {
+		return nil, ErrMFANotEnrolled
+	}
+
+	options, session, err := s.webauthn.BeginLogin(user)
+	if err != nil {
+		return nil, err
+	}
+	err = s.challengeRepo.SaveWebAuthnSession(challengeToken, &models.WebAuthnSession{
+		Purpose: models.WebAuthnMFA,
+		UserID:  challenge.UserID,
+		Data:    *session,
+	}, webAuthnSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// verifyMFA checks the assertion answering BeginMFA for the user.
+func (s *WebAuthnService) verifyMFA(challengeToken string, userID uint, response []byte) error {
+	session, err := s.consumeSession(challengeToken, models.WebAuthnMFA)
+	if err != nil || session.UserID != userID {
+		return ErrInvalidMFACode
+	}
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return ErrInvalidMFACode
+	}
+	credential, err := s.webauthn.ValidateLogin(user, session.Data, parsed)
+	if err != nil {
+		return ErrInvalidMFACode
+	}
+	return s.recordUse(user, credential)
+}
+
+// recordUse stores the new signature counter. A counter that did not increase
+// means the credential may have been cloned, and the login is refused.
+func (s *WebAuthnService) recordUse(user *webAuthnUser, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return ErrWebAuthnFailed
+	}
+	stored := user.stored(credential.ID)
+	if stored == nil {
+		return ErrWebAuthnFailed
+	}
+
+	now := time.Now()
+	stored.SignCount = credential.Authenticator.SignCount
+	stored.Flags = uint8(credential.Flags.ProtocolValue())
+	stored.LastUsedAt = &now
+	return s.mfaRepo.SaveWebAuthnCredential(stored)
+}
+
+func (s *WebAuthnService) saveSession(session *models.WebAuthnSession) (string, error) {
+	sessionID, err := utils.GenerateSecret(32)
+	if err != nil {
+		return "", err
+	}
+	if err := s.challengeRepo.SaveWebAuthnSession(sessionID, session, webAuthnSessionTTL); err != nil {
+		return "", err
+	}
+	return sessionID, nil
+}
+
+func (s *WebAuthnService) consumeSession(sessionID, purpose string) (*models.WebAuthnSession, error) {
+	session, err := s.challengeRepo.ConsumeWebAuthnSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Purpose != purpose {
+		return nil, ErrWebAuthnSession
+	}
+	return session, nil
+}
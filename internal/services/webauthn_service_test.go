@@ -0,0 +1,250 @@
+package services_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"auth-service/internal/models"
+	"auth-service/internal/services"
+	"auth-service/internal/utils"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+const testOrigin = "http://localhost:8888"
+
+// softAuthenticator is a minimal platform authenticator holding a single
+// P-256 key, producing "none" attestations.
+type softAuthenticator struct {
+	t          *testing.T
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": testOrigin})
+	assert.NoError(a.t, err)
+	return data
+}
+
+// authData builds authenticator data with user presence and verification.
+func (a *softAuthenticator) authData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	flags := byte(0x01 | 0x04)
+	if attested != nil {
+		flags |= 0x40
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) create(challenge string, userHandle []byte) []byte {
+	a.userHandle = userHandle
+
+	cose, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: 2, Algorithm: -7},
+		Curve:         1,
+		XCoord:        a.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	assert.NoError(a.t, err)
+
+	attested := make([]byte, 16) // Zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(append(attested, a.id...), cose...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(attested),
+	})
+	assert.NoError(a.t, err)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+	assert.NoError(a.t, err)
+	return body
+}
+
+func (a *softAuthenticator) get(challenge string) []byte {
+	a.signCount++
+	authData := a.authData(nil)
+	clientData := a.clientData("webauthn.get", challenge)
+
+	hash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, hash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.NoError(a.t, err)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	assert.NoError(a.t, err)
+	return body
+}
+
+// webAuthnSession keeps the session handed to the next SaveWebAuthnSession
+// so the following ConsumeWebAuthnSession can return it.
+func (e *testEnv) webAuthnSession() *models.WebAuthnSession {
+	session := &models.WebAuthnSession{}
+	e.challengeRepo.On("SaveWebAuthnSession", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*session = *args.Get(1).(*models.WebAuthnSession)
+	}).Return(nil).Once()
+	e.challengeRepo.On("ConsumeWebAuthnSession", mock.Anything).Return(session, nil).Once()
+	return session
+}
+
+// registerPasskey runs a registration ceremony and returns the stored
+// credential.
+func (e *testEnv) registerPasskey(t *testing.T, user *models.User, authenticator *softAuthenticator) *models.WebAuthnCredential {
+	passkeys := e.webAuthnService(t)
+	session := e.webAuthnSession()
+	e.userRepo.On("FindByID", user.ID).Return(user, nil)
+	e.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return(nil, nil).Twice()
+	e.mfaRepo.On("SaveWebAuthnCredential", mock.Anything).Return(nil).Once()
+
+	sessionID, options, err := passkeys.BeginRegistration(user.ID, "Laptop")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, sessionID)
+	assert.Equal(t, "localhost", options.Response.RelyingParty.ID)
+
+	response := authenticator.create(session.Data.Challenge, options.Response.User.ID.(protocol.URLEncodedBase64))
+	cred, err := passkeys.FinishRegistration(user.ID, sessionID, response)
+	assert.NoError(t, err)
+	return cred
+}
+
+func TestWebAuthn_RegisterAndPasskeyLogin(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	passkeys := env.webAuthnService(t)
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	authenticator := newSoftAuthenticator(t)
+
+	// Execute & Assert: registration stores the credential
+	cred := env.registerPasskey(t, user, authenticator)
+	assert.Equal(t, authenticator.id, cred.CredentialID)
+	assert.Equal(t, "Laptop", cred.Name)
+	assert.Equal(t, "none", cred.AttestationType)
+
+	session := env.webAuthnSession()
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return([]models.WebAuthnCredential{*cred}, nil)
+	env.mfaRepo.On("SaveWebAuthnCredential", mock.MatchedBy(func(c *models.WebAuthnCredential) bool {
+		return c.SignCount == 1 && c.LastUsedAt != nil
+	})).Return(nil).Once()
+	env.authRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	// and logs in with it
+	sessionID, _, err := passkeys.BeginLogin()
+	assert.NoError(t, err)
+	token, err := passkeys.FinishLogin(sessionID, authenticator.get(session.Data.Challenge), services.ClientInfo{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+	env.mfaRepo.AssertExpectations(t)
+}
+
+func TestWebAuthn_CloneWarning(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	passkeys := env.webAuthnService(t)
+	user := &models.User{ID: 1, Email: "john@example.com"}
+	authenticator := newSoftAuthenticator(t)
+
+	cred := env.registerPasskey(t, user, authenticator)
+	// Another copy of the key already signed with a higher counter
+	cred.SignCount = 5
+
+	session := env.webAuthnSession()
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return([]models.WebAuthnCredential{*cred}, nil)
+
+	// Execute & Assert
+	sessionID, _, err := passkeys.BeginLogin()
+	assert.NoError(t, err)
+	_, err = passkeys.FinishLogin(sessionID, authenticator.get(session.Data.Challenge), services.ClientInfo{})
+	assert.ErrorIs(t, err, services.ErrWebAuthnFailed)
+	env.authRepo.AssertNotCalled(t, "CreateAuth", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMFAVerify_WebAuthn(t *testing.T) {
+	// Setup
+	env := newTestEnv(t, mfaConfig())
+	service := env.mfaService(t)
+	passkeys := env.webAuthnService(t)
+	hashedPassword, _ := utils.HashPassword("password123")
+	user := &models.User{ID: 1, Email: "john@example.com", Password: hashedPassword}
+	authenticator := newSoftAuthenticator(t)
+
+	cred := env.registerPasskey(t, user, authenticator)
+
+	// A passkey alone is offered as the second factor, without recovery codes
+	env.userRepo.On("FindByEmail", user.Email).Return(user, nil)
+	env.mfaRepo.On("FindTOTP", user.ID).Return(nil, gorm.ErrRecordNotFound)
+	env.mfaRepo.On("ListWebAuthnCredentials", user.ID).Return([]models.WebAuthnCredential{*cred}, nil)
+	env.challengeRepo.On("SaveMFAChallenge", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	// Execute & Assert
+	_, err := env.authService.Login(user.Email, "password123", services.ClientInfo{})
+	var mfaErr *services.MFARequiredError
+	assert.ErrorAs(t, err, &mfaErr)
+	assert.Equal(t, []string{services.MFAMethodWebAuthn}, mfaErr.Methods)
+
+	session := env.webAuthnSession()
+	env.challengeRepo.On("FetchMFAChallenge", mfaErr.Token).Return(&models.MFAChallenge{UserID: user.ID}, nil)
+	env.challengeRepo.On("RecordMFAAttempt", mfaErr.Token).Return(int64(1), nil)
+	env.challengeRepo.On("CountMFAFailures", user.ID).Return(int64(0), nil)
+	env.challengeRepo.On("ResetMFAFailures", user.ID).Return(nil)
+	env.challengeRepo.On("DeleteMFAChallenge", mfaErr.Token).Return(true, nil)
+	env.mfaRepo.On("SaveWebAuthnCredential", mock.Anything).Return(nil)
+	env.authRepo.On("CreateAuth", user.ID, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	env.authRepo.On("SaveSession", mock.Anything).Return(nil)
+
+	options, err := passkeys.BeginMFA(mfaErr.Token)
+	assert.NoError(t, err)
+	assert.Len(t, options.Response.AllowedCredentials, 1)
+
+	token, err := service.Verify(mfaErr.Token, services.MFAMethodWebAuthn, string(authenticator.get(session.Data.Challenge)), false)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+}
@@ -0,0 +1,88 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Sender interface {
+	Send(to, message string) error
+}
+
+// WebhookSender hands messages to an SMS gateway over HTTP. It posts
+// {"to": "+15551234567", "message": "..."} as JSON, with the token as a
+// bearer credential when set; any 2xx answer counts as accepted.
+type WebhookSender struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewWebhookSender(url, token string) *WebhookSender {
+	return &WebhookSender{url: url, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *WebhookSender) Send(to, message string) error {
+	body, err := json.Marshal(map[string]string{"to": to, "message": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sms webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+type Message struct {
+	To   string
+	Body string
+}
+
+// FakeSender logs messages instead of sending them and keeps them in memory,
+// for local development and tests.
+type FakeSender struct {
+	mu       sync.Mutex
+	Messages []Message
+}
+
+func (s *FakeSender) Send(to, message string) error {
+	log.Printf("SMS to %s: %s", to, message)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Messages = append(s.Messages, Message{To: to, Body: message})
+	return nil
+}
+
+// Last returns the most recent message, or nil if none was sent.
+func (s *FakeSender) Last() *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.Messages) == 0 {
+		return nil
+	}
+	msg := s.Messages[len(s.Messages)-1]
+	return &msg
+}
@@ -1,74 +1,294 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 
+	"auth-service/internal/config"
+
 	"golang.org/x/crypto/argon2"
 )
 
+// ArgonConfig holds the Argon2id parameters of new hashes. Memory is in KiB.
 type ArgonConfig struct {
-	time    uint32
-	memory  uint32
-	threads uint8
-	keyLen  uint32
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
 }
 
 var argonConfig = &ArgonConfig{
-	time:    1,
-	memory:  64 * 1024,
-	threads: 4,
-	keyLen:  32,
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
 }
 
+// SetArgonConfig changes the parameters of new hashes. Existing hashes keep
+// verifying with the parameters encoded in them, until NeedsRehash replaces
+// them. It is meant to be called once at startup.
+func SetArgonConfig(c ArgonConfig) error {
+	if c.Time < 1 || c.Threads < 1 || c.Memory < 8*uint32(c.Threads) || c.KeyLen < 16 {
+		return fmt.Errorf("invalid argon2 parameters: t=%d, m=%d, p=%d, key length %d", c.Time, c.Memory, c.Threads, c.KeyLen)
+	}
+	argonConfig = &c
+	return nil
+}
+
+// Pepper is a secret mixed into password hashes with HMAC-SHA256 before
+// Argon2id. It is kept out of the database, so a dump alone is not enough to
+// brute-force the hashes.
+type Pepper struct {
+	Version int
+	Secret  []byte
+}
+
+// Peppers must be long enough not to be guessed along with the password.
+const minPepperLength = 32
+
+var (
+	peppers       = map[int][]byte{}
+	currentPepper int // Version used by new hashes, 0 for none
+)
+
+// SetPeppers replaces the known peppers. New hashes use the highest version,
+// and hashes made with any listed version keep verifying. Like
+// SetArgonConfig, it is meant to be called once at startup.
+func SetPeppers(list []Pepper) error {
+	known := make(map[int][]byte, len(list))
+	current := 0
+	for _, p := range list {
+		if p.Version < 1 {
+			return fmt.Errorf("invalid pepper version %d", p.Version)
+		}
+		if _, ok := known[p.Version]; ok {
+			return fmt.Errorf("duplicate pepper version %d", p.Version)
+		}
+		if len(p.Secret) < minPepperLength {
+			return fmt.Errorf("pepper %d is shorter than %d bytes", p.Version, minPepperLength)
+		}
+		known[p.Version] = p.Secret
+		current = max(current, p.Version)
+	}
+	peppers, currentPepper = known, current
+	return nil
+}
+
+// PepperVersions returns the versions of the known peppers, oldest first.
+func PepperVersions() []int {
+	versions := make([]int, 0, len(peppers))
+	for version := range peppers {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// ConfigureArgon applies the ARGON2_* settings and the PASSWORD_PEPPERS.
+func ConfigureArgon(cfg *config.Config) error {
+	err := SetArgonConfig(ArgonConfig{
+		Time:    uint32(cfg.Argon2Time),
+		Memory:  uint32(cfg.Argon2Memory),
+		Threads: uint8(cfg.Argon2Threads),
+		KeyLen:  argonConfig.KeyLen,
+	})
+	if err != nil {
+		return err
+	}
+
+	var list []Pepper
+	for _, item := range strings.Split(cfg.PasswordPeppers, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		version, secret, ok := strings.Cut(item, ":")
+		v, err := strconv.Atoi(version)
+		if !ok || err != nil {
+			return errors.New("invalid PASSWORD_PEPPERS entry, expected <version>:<secret>")
+		}
+		list = append(list, Pepper{Version: v, Secret: []byte(secret)})
+	}
+	return SetPeppers(list)
+}
+
+// pepper returns the Argon2id input for a password under a pepper version.
+func pepper(password string, version int) ([]byte, error) {
+	if version == 0 {
+		return []byte(password), nil
+	}
+	secret, ok := peppers[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown pepper version %d", version)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
+
+// HashPassword hashes a password with the current Argon2id parameters and
+// the newest pepper, whose version is stored as the keyid parameter.
 func HashPassword(password string) (string, error) {
+	return hashArgon(password, currentPepper)
+}
+
+// HashSecret hashes a generated credential, such as a client secret, without
+// a pepper. Such secrets are too random to brute-force, and a peppered hash
+// would keep its pepper version from ever leaving PASSWORD_PEPPERS. The
+// hashes verify with VerifyPassword.
+func HashSecret(secret string) (string, error) {
+	return hashArgon(secret, 0)
+}
+
+func hashArgon(password string, version int) (string, error) {
 	salt := make([]byte, 16)
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, argonConfig.time, argonConfig.memory, argonConfig.threads, argonConfig.keyLen)
+	c := argonConfig
+	input, err := pepper(password, version)
+	if err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey(input, salt, c.Time, c.Memory, c.Threads, c.KeyLen)
 
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
 
-	encodedHash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonConfig.memory, argonConfig.time, argonConfig.threads, b64Salt, b64Hash)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", c.Memory, c.Time, c.Threads)
+	if version != 0 {
+		params += fmt.Sprintf(",keyid=%d", version)
+	}
+	encodedHash := fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, b64Salt, b64Hash)
 
 	return encodedHash, nil
 }
 
 func VerifyPassword(password, encodedHash string) (bool, error) {
-	parts := strings.Split(encodedHash, "$")
-	if len(parts) != 6 {
-		return false, fmt.Errorf("invalid hash format")
-	}
-
-	var memory, time uint32
-	var threads uint8
-
-	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	h, err := decodeArgonHash(encodedHash)
 	if err != nil {
 		return false, err
 	}
-
-	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	input, err := pepper(password, h.pepper)
 	if err != nil {
 		return false, err
 	}
 
-	decodedHash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	comparisonHash := argon2.IDKey(input, h.salt, h.params.Time, h.params.Memory, h.params.Threads, h.params.KeyLen)
+	return subtle.ConstantTimeCompare(comparisonHash, h.key) == 1, nil
+}
+
+// NeedsRehash reports whether a hash that just verified was made with weaker
+// parameters than new hashes get, or with another pepper than the newest.
+func NeedsRehash(encodedHash string) bool {
+	return needsRehash(encodedHash, currentPepper)
+}
+
+// NeedsSecretRehash is NeedsRehash for hashes made by HashSecret, which
+// should have no pepper.
+func NeedsSecretRehash(encodedHash string) bool {
+	return needsRehash(encodedHash, 0)
+}
+
+func needsRehash(encodedHash string, version int) bool {
+	h, err := decodeArgonHash(encodedHash)
 	if err != nil {
-		return false, err
+		return true
 	}
+	c, params := argonConfig, h.params
+	return params.Time < c.Time || params.Memory < c.Memory || params.Threads < c.Threads || params.KeyLen < c.KeyLen ||
+		h.pepper != version
+}
 
-	keyLen := uint32(len(decodedHash))
+type argonHash struct {
+	params ArgonConfig
+	pepper int
+	salt   []byte
+	key    []byte
+}
 
-	comparisonHash := argon2.IDKey([]byte(password), salt, time, memory, threads, keyLen)
+// decodeArgonHash splits $argon2id$v=19$m=65536,t=1,p=4[,keyid=N]$<salt>$<hash>.
+func decodeArgonHash(encodedHash string) (*argonHash, error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("invalid hash format")
+	}
 
-	if string(comparisonHash) == string(decodedHash) {
-		return true, nil
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	h := &argonHash{}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argon2 parameter %q", param)
+		}
+		switch name {
+		case "m":
+			h.params.Memory = uint32(n)
+		case "t":
+			h.params.Time = uint32(n)
+		case "p":
+			if n > 255 {
+				return nil, fmt.Errorf("invalid argon2 parameter %q", param)
+			}
+			h.params.Threads = uint8(n)
+		case "keyid":
+			h.pepper = int(n)
+		default:
+			return nil, fmt.Errorf("unknown argon2 parameter %q", name)
+		}
+	}
+	if h.params.Time < 1 || h.params.Threads < 1 {
+		return nil, errors.New("invalid hash format")
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(h.key) == 0 {
+		return nil, errors.New("invalid hash format")
+	}
+	h.params.KeyLen = uint32(len(h.key))
+	return h, nil
+}
+
+// GenerateNumericCode returns a uniformly random code of the given number of
+// decimal digits, keeping leading zeros.
+func GenerateNumericCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// GenerateSecret returns n random bytes encoded as unpadded base64url, for
+// client secrets and other opaque credentials.
+func GenerateSecret(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return false, nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,151 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"auth-service/internal/config"
+	"auth-service/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// defaultArgon restores the default parameters once the test is done.
+func defaultArgon(t *testing.T) {
+	t.Cleanup(func() {
+		utils.SetArgonConfig(utils.ArgonConfig{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32})
+	})
+}
+
+func TestConfigureArgon(t *testing.T) {
+	// Setup
+	defaultArgon(t)
+
+	// Execute
+	err := utils.ConfigureArgon(&config.Config{Argon2Time: 3, Argon2Memory: 32 * 1024, Argon2Threads: 2})
+
+	// Assert: the parameters are encoded in new hashes
+	assert.NoError(t, err)
+	hash, err := utils.HashPassword("password123")
+	assert.NoError(t, err)
+	assert.Contains(t, hash, "$argon2id$v=19$m=32768,t=3,p=2$")
+
+	// Parameters too weak to be meaningful are refused
+	assert.Error(t, utils.ConfigureArgon(&config.Config{Argon2Time: 0, Argon2Memory: 64 * 1024, Argon2Threads: 4}))
+	assert.Error(t, utils.ConfigureArgon(&config.Config{Argon2Time: 1, Argon2Memory: 16, Argon2Threads: 4}))
+}
+
+func TestNeedsRehash(t *testing.T) {
+	// Setup
+	defaultArgon(t)
+	oldHash, err := utils.HashPassword("password123")
+	assert.NoError(t, err)
+	assert.False(t, utils.NeedsRehash(oldHash))
+
+	// Execute: raise the parameters of new hashes
+	assert.NoError(t, utils.SetArgonConfig(utils.ArgonConfig{Time: 2, Memory: 64 * 1024, Threads: 4, KeyLen: 32}))
+
+	// Assert: older hashes still verify but need a rehash
+	match, err := utils.VerifyPassword("password123", oldHash)
+	assert.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, utils.NeedsRehash(oldHash))
+
+	newHash, err := utils.HashPassword("password123")
+	assert.NoError(t, err)
+	assert.Contains(t, newHash, "$m=65536,t=2,p=4$")
+	assert.False(t, utils.NeedsRehash(newHash))
+
+	// Hashes stronger than required are left alone
+	assert.NoError(t, utils.SetArgonConfig(utils.ArgonConfig{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLen: 32}))
+	assert.False(t, utils.NeedsRehash(newHash))
+
+	// Anything that is not an Argon2id hash is replaced
+	assert.True(t, utils.NeedsRehash("$2a$10$abcdefghijklmnopqrstuv"))
+}
+
+func TestPasswordHash_PepperVersions(t *testing.T) {
+	// Setup
+	t.Cleanup(func() { utils.SetPeppers(nil) })
+	v1 := utils.Pepper{Version: 1, Secret: []byte(strings.Repeat("a", 32))}
+	v2 := utils.Pepper{Version: 2, Secret: []byte(strings.Repeat("b", 32))}
+
+	unpeppered, err := utils.HashPassword("password123")
+	assert.NoError(t, err)
+	assert.NotContains(t, unpeppered, "keyid")
+
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{v1}))
+	hash1, err := utils.HashPassword("password123")
+	assert.NoError(t, err)
+	assert.Contains(t, hash1, ",keyid=1$")
+
+	// Execute: rotate to a second pepper
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{v2, v1}))
+	hash2, err := utils.HashPassword("password123")
+	assert.NoError(t, err)
+
+	// Assert: new hashes use the newest version, and every hash verifies
+	assert.Contains(t, hash2, ",keyid=2$")
+	assert.Equal(t, []int{1, 2}, utils.PepperVersions())
+	for _, hash := range []string{unpeppered, hash1, hash2} {
+		match, err := utils.VerifyPassword("password123", hash)
+		assert.NoError(t, err)
+		assert.True(t, match)
+		match, err = utils.VerifyPassword("wrongpass", hash)
+		assert.NoError(t, err)
+		assert.False(t, match)
+	}
+
+	// Only hashes on the newest pepper are left alone
+	assert.True(t, utils.NeedsRehash(unpeppered))
+	assert.True(t, utils.NeedsRehash(hash1))
+	assert.False(t, utils.NeedsRehash(hash2))
+
+	// The pepper is part of the hash: the same key under another version fails
+	mismatched := strings.Replace(hash1, ",keyid=1$", ",keyid=2$", 1)
+	match, err := utils.VerifyPassword("password123", mismatched)
+	assert.NoError(t, err)
+	assert.False(t, match)
+
+	// Without its pepper a hash cannot be verified
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{v1}))
+	_, err = utils.VerifyPassword("password123", hash2)
+	assert.Error(t, err)
+	assert.True(t, utils.NeedsRehash(hash2))
+}
+
+func TestSetPeppers_Invalid(t *testing.T) {
+	// Setup
+	t.Cleanup(func() { utils.SetPeppers(nil) })
+
+	// Execute & Assert: short secrets, bad or duplicate versions
+	assert.Error(t, utils.SetPeppers([]utils.Pepper{{Version: 3, Secret: []byte("short")}}))
+	assert.Error(t, utils.SetPeppers([]utils.Pepper{{Version: 0, Secret: []byte(strings.Repeat("a", 32))}}))
+	assert.Error(t, utils.SetPeppers([]utils.Pepper{
+		{Version: 1, Secret: []byte(strings.Repeat("a", 32))},
+		{Version: 1, Secret: []byte(strings.Repeat("b", 32))},
+	}))
+	assert.Error(t, utils.ConfigureArgon(&config.Config{
+		Argon2Time: 1, Argon2Memory: 64 * 1024, Argon2Threads: 4,
+		PasswordPeppers: "x:" + strings.Repeat("a", 32),
+	}))
+}
+
+func TestHashSecret_IgnoresPepper(t *testing.T) {
+	// Setup
+	t.Cleanup(func() { utils.SetPeppers(nil) })
+	assert.NoError(t, utils.SetPeppers([]utils.Pepper{{Version: 1, Secret: []byte(strings.Repeat("a", 32))}}))
+
+	// Execute
+	hash, err := utils.HashSecret("s3cret")
+
+	// Assert: verifies without the pepper, and needs no rehash to one
+	assert.NoError(t, err)
+	assert.NotContains(t, hash, "keyid")
+	assert.False(t, utils.NeedsSecretRehash(hash))
+	assert.True(t, utils.NeedsRehash(hash))
+	assert.NoError(t, utils.SetPeppers(nil))
+	match, err := utils.VerifyPassword("s3cret", hash)
+	assert.NoError(t, err)
+	assert.True(t, match)
+}
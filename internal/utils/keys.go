@@ -0,0 +1,390 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"auth-service/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a single JWT key identified by its kid. For HMAC keys Private
+// and Public both hold the shared secret.
+//
+// A key starts signing at ActivatesAt and keeps verifying until ExpiresAt,
+// which stays zero until a newer key supersedes it.
+type SigningKey struct {
+	ID          string
+	Method      jwt.SigningMethod
+	Private     interface{}
+	Public      interface{}
+	CreatedAt   time.Time
+	ActivatesAt time.Time
+	ExpiresAt   time.Time
+}
+
+// KeySet is a key ring: the newest activated key signs, while pending and
+// retired keys still verify until they expire.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys map[string]*SigningKey
+}
+
+// TokenKeys groups the rings used for access and refresh tokens.
+type TokenKeys struct {
+	Access  *KeySet
+	Refresh *KeySet
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeySet(keys ...*SigningKey) *KeySet {
+	ks := &KeySet{}
+	ks.Replace(keys)
+	return ks
+}
+
+// Replace swaps the content of the ring, e.g. after reloading it from a
+// KeyStore.
+func (ks *KeySet) Replace(keys []*SigningKey) {
+	m := make(map[string]*SigningKey, len(keys))
+	for _, k := range keys {
+		m[k.ID] = k
+	}
+
+	ks.mu.Lock()
+	ks.keys = m
+	ks.mu.Unlock()
+}
+
+// Keys returns a snapshot of every key in the ring.
+func (ks *KeySet) Keys() []*SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActivatesAt.Before(keys[j].ActivatesAt) })
+	return keys
+}
+
+// Active returns the key currently used for signing.
+func (ks *KeySet) Active() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return activeKey(ks.keys, time.Now())
+}
+
+func activeKey(keys map[string]*SigningKey, now time.Time) *SigningKey {
+	var active *SigningKey
+	for _, k := range keys {
+		if k.ActivatesAt.After(now) || k.expired(now) {
+			continue
+		}
+		if active == nil || k.ActivatesAt.After(active.ActivatesAt) {
+			active = k
+		}
+	}
+	return active
+}
+
+func (k *SigningKey) expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// LoadTokenKeys builds the access and refresh key rings. Without JWT_KEYS_DIR
+// each ring holds the single static key from configuration; with it, the rings
+// are loaded from disk and seeded with the static keys on first start.
+func LoadTokenKeys(cfg *config.Config) (*TokenKeys, error) {
+	access, err := staticAccessKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	refresh := NewHMACKey("", []byte(cfg.RefreshSecret))
+
+	if cfg.JWTKeysDir == "" {
+		return &TokenKeys{Access: NewKeySet(access), Refresh: NewKeySet(refresh)}, nil
+	}
+
+	store := NewFileKeyStore(cfg.JWTKeysDir)
+	keys := &TokenKeys{}
+	if keys.Access, err = loadRing(store, AccessRing, access); err != nil {
+		return nil, err
+	}
+	if keys.Refresh, err = loadRing(store, RefreshRing, refresh); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func loadRing(store KeyStore, ring string, seed *SigningKey) (*KeySet, error) {
+	keys, err := store.Load(ring)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if err := store.Save(ring, seed); err != nil {
+			return nil, err
+		}
+		keys = []*SigningKey{seed}
+	}
+	return NewKeySet(keys...), nil
+}
+
+// staticAccessKey reads the configured access token key. HS256 uses
+// JWT_SECRET; asymmetric algorithms read a PEM private key from
+// JWT_PRIVATE_KEY_PATH, or generate an ephemeral key when none is configured.
+func staticAccessKey(cfg *config.Config) (*SigningKey, error) {
+	alg := cfg.JWTAlgorithm
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+
+	switch {
+	case alg == jwt.SigningMethodHS256.Alg():
+		return NewHMACKey(cfg.JWTKeyID, []byte(cfg.JWTSecret)), nil
+	case cfg.JWTPrivateKeyPath != "":
+		pemBytes, err := os.ReadFile(cfg.JWTPrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return ParseSigningKey(alg, cfg.JWTKeyID, pemBytes)
+	default:
+		if cfg.JWTKeysDir == "" {
+			log.Printf("Warning: JWT_PRIVATE_KEY_PATH not set, generating an ephemeral %s key", alg)
+		}
+		return GenerateSigningKey(alg)
+	}
+}
+
+func NewHMACKey(kid string, secret []byte) *SigningKey {
+	if kid == "" {
+		sum := sha256.Sum256(secret)
+		kid = hex.EncodeToString(sum[:8])
+	}
+	now := time.Now()
+	return &SigningKey{
+		ID:          kid,
+		Method:      jwt.SigningMethodHS256,
+		Private:     secret,
+		Public:      secret,
+		CreatedAt:   now,
+		ActivatesAt: now,
+	}
+}
+
+// ParseSigningKey reads a PEM encoded private key for the given algorithm.
+func ParseSigningKey(alg, kid string, pemBytes []byte) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+	case jwt.SigningMethodES256.Alg():
+		private, err = jwt.ParseECPrivateKeyFromPEM(pemBytes)
+	case jwt.SigningMethodEdDSA.Alg():
+		var k crypto.PrivateKey
+		k, err = jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+		if err == nil {
+			private = k.(ed25519.PrivateKey)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newAsymmetricKey(alg, kid, private)
+}
+
+// GenerateSigningKey creates a fresh key for the given algorithm.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACKey("", secret), nil
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newAsymmetricKey(alg, "", private)
+}
+
+func newAsymmetricKey(alg, kid string, private crypto.Signer) (*SigningKey, error) {
+	public := private.Public()
+	if kid == "" {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		kid = hex.EncodeToString(sum[:8])
+	}
+	now := time.Now()
+	return &SigningKey{
+		ID:          kid,
+		Method:      jwt.GetSigningMethod(alg),
+		Private:     private,
+		Public:      public,
+		CreatedAt:   now,
+		ActivatesAt: now,
+	}, nil
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.Active()
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key for a token by its kid header and
+// rejects tokens whose alg does not match the key. Tokens without a kid fall
+// back to the active key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	key := activeKey(ks.keys, now)
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok = ks.keys[kid]
+		if !ok || key.expired(now) {
+			return nil, fmt.Errorf("unknown key id: %s", kid)
+		}
+	}
+	if key == nil {
+		return nil, errors.New("no active signing key")
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// Parse verifies a token against the key set and returns its claims.
+func (ks *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, ks.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys of the set, including pending and retired
+// keys so verifiers learn about a new key before it starts signing. HMAC keys
+// are never published.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		if key.expired(now) {
+			continue
+		}
+		if jwk, ok := key.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// Asymmetric reports whether the key is published in the JWKS, so that third
+// parties can verify its tokens without holding the signing secret.
+func (k *SigningKey) Asymmetric() bool {
+	_, ok := k.jwk()
+	return ok
+}
+
+func (k *SigningKey) jwk() (JWK, bool) {
+	jwk := JWK{Use: "sig", Alg: k.Method.Alg(), Kid: k.ID}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64url(pub.N.Bytes())
+		jwk.E = b64url(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecdh, err := pub.ECDH()
+		if err != nil {
+			return jwk, false
+		}
+		// Uncompressed point: 0x04 || X || Y
+		raw := ecdh.Bytes()
+		size := (len(raw) - 1) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64url(raw[1 : 1+size])
+		jwk.Y = b64url(raw[1+size:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64url(pub)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
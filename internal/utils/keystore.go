@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"auth-service/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessRing  = "access"
+	RefreshRing = "refresh"
+
+	// Extra time a retired key keeps verifying on top of the token lifetime
+	keyRetentionSkew = time.Minute
+)
+
+type KeyStore interface {
+	Load(ring string) ([]*SigningKey, error)
+	Save(ring string, key *SigningKey) error
+	Delete(ring, kid string) error
+}
+
+// FileKeyStore keeps one PEM file per key under <dir>/<ring>/<kid>.pem so
+// every instance sharing the directory sees the same rings.
+type FileKeyStore struct {
+	dir string
+}
+
+func NewFileKeyStore(dir string) *FileKeyStore {
+	return &FileKeyStore{dir}
+}
+
+func (s *FileKeyStore) Load(ring string) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, ring, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := decodeKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *FileKeyStore) Save(ring string, key *SigningKey) error {
+	data, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.dir, ring)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	// Write then rename so other instances never read a partial file
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, key.ID+".pem"))
+}
+
+func (s *FileKeyStore) Delete(ring, kid string) error {
+	err := os.Remove(filepath.Join(s.dir, ring, kid+".pem"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func encodeKey(key *SigningKey) ([]byte, error) {
+	block := &pem.Block{
+		Headers: map[string]string{
+			"Kid":       key.ID,
+			"Alg":       key.Method.Alg(),
+			"Created":   key.CreatedAt.UTC().Format(time.RFC3339Nano),
+			"Activates": key.ActivatesAt.UTC().Format(time.RFC3339Nano),
+		},
+	}
+	if !key.ExpiresAt.IsZero() {
+		block.Headers["Expires"] = key.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	if secret, ok := key.Private.([]byte); ok {
+		block.Type = "HMAC SECRET"
+		block.Bytes = secret
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+		if err != nil {
+			return nil, err
+		}
+		block.Type = "PRIVATE KEY"
+		block.Bytes = der
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func decodeKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	kid, alg := block.Headers["Kid"], block.Headers["Alg"]
+	var key *SigningKey
+	var err error
+	if block.Type == "HMAC SECRET" {
+		key = NewHMACKey(kid, block.Bytes)
+	} else {
+		// Strip headers so the jwt PEM parsers accept the block
+		key, err = ParseSigningKey(alg, kid, pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: block.Bytes}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for header, field := range map[string]*time.Time{
+		"Created":   &key.CreatedAt,
+		"Activates": &key.ActivatesAt,
+		"Expires":   &key.ExpiresAt,
+	} {
+		value, ok := block.Headers[header]
+		if !ok {
+			*field = time.Time{}
+			continue
+		}
+		if *field, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// KeyRotator keeps a KeySet in sync with a KeyStore. It retires keys that a
+// newer key has superseded, prunes keys whose tokens can no longer be valid and
+// rotates automatically once the active key is older than the interval.
+type KeyRotator struct {
+	Ring            string
+	store           KeyStore
+	keys            *KeySet
+	alg             string
+	interval        time.Duration
+	activationDelay time.Duration
+	retention       time.Duration
+}
+
+func NewKeyRotator(ring string, store KeyStore, keys *KeySet, alg string, interval, activationDelay, retention time.Duration) *KeyRotator {
+	return &KeyRotator{
+		Ring:            ring,
+		store:           store,
+		keys:            keys,
+		alg:             alg,
+		interval:        interval,
+		activationDelay: activationDelay,
+		retention:       retention + keyRetentionSkew,
+	}
+}
+
+// NewKeyRotators returns the rotators for the access and refresh rings stored
+// in JWT_KEYS_DIR.
+func NewKeyRotators(cfg *config.Config, keys *TokenKeys) []*KeyRotator {
+	store := NewFileKeyStore(cfg.JWTKeysDir)
+	accessAlg := cfg.JWTAlgorithm
+	if accessAlg == "" {
+		accessAlg = jwt.SigningMethodHS256.Alg()
+	}
+	return []*KeyRotator{
+		NewKeyRotator(AccessRing, store, keys.Access, accessAlg, cfg.JWTKeyRotationInterval, cfg.JWTKeyActivationDelay, AccessTokenTTL),
+		NewKeyRotator(RefreshRing, store, keys.Refresh, jwt.SigningMethodHS256.Alg(), cfg.JWTKeyRotationInterval, cfg.JWTKeyActivationDelay, RefreshTokenTTL),
+	}
+}
+
+// Rotate adds a new signing key to the ring. Unless immediate, the key is only
+// published for verification until the activation delay has passed, giving
+// other instances and JWKS caches time to pick it up.
+func (r *KeyRotator) Rotate(immediate bool) (*SigningKey, error) {
+	key, err := GenerateSigningKey(r.alg)
+	if err != nil {
+		return nil, err
+	}
+	if !immediate {
+		key.ActivatesAt = key.CreatedAt.Add(r.activationDelay)
+	}
+
+	if err := r.store.Save(r.Ring, key); err != nil {
+		return nil, err
+	}
+	return key, r.Sync()
+}
+
+// Sync reloads the ring from the store and applies retirement, pruning and
+// scheduled rotation.
+func (r *KeyRotator) Sync() error {
+	keys, err := r.store.Load(r.Ring)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	byID := make(map[string]*SigningKey, len(keys))
+	for _, k := range keys {
+		byID[k.ID] = k
+	}
+	active := activeKey(byID, now)
+
+	current := keys[:0]
+	pending := false
+	for _, k := range keys {
+		switch {
+		case k.expired(now):
+			if err := r.store.Delete(r.Ring, k.ID); err != nil {
+				return err
+			}
+			continue
+		case k.ActivatesAt.After(now):
+			pending = true
+		case active != nil && k != active && k.ExpiresAt.IsZero():
+			k.ExpiresAt = active.ActivatesAt.Add(r.retention)
+			if err := r.store.Save(r.Ring, k); err != nil {
+				return err
+			}
+		}
+		current = append(current, k)
+	}
+	r.keys.Replace(current)
+
+	if r.interval > 0 && !pending && active != nil && now.Sub(active.ActivatesAt) >= r.interval {
+		log.Printf("Rotating %s signing key %s", r.Ring, active.ID)
+		_, err := r.Rotate(false)
+		return err
+	}
+	return nil
+}
+
+// Run syncs the ring every period until the context is cancelled.
+func (r *KeyRotator) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sync(); err != nil {
+				log.Printf("Failed to sync %s key ring: %v", r.Ring, err)
+			}
+		}
+	}
+}
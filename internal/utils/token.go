@@ -1,39 +1,116 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
-	"auth-service/internal/config"
-
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const (
+	AccessTokenTTL  = time.Minute * 15
+	RefreshTokenTTL = time.Hour * 24 * 7
+)
+
+// Access tokens are issued either to a user or, through the client
+// credentials grant, to a service acting on its own behalf.
+const (
+	SubjectUser   = "user"
+	SubjectClient = "client"
+)
+
+// Authentication methods (RFC 8176) recorded in the amr claim.
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp" // TOTP, recovery and email codes
+	AMRSMS         = "sms"
+	AMRHardwareKey = "hwk" // WebAuthn credentials
+	AMRMFA         = "mfa"
+)
+
+// Authentication context classes in the acr claim, from weakest to
+// strongest.
+const (
+	ACRBasic = "basic"
+	ACRMFA   = "mfa"
+)
+
+// ACR returns the authentication context class reached with the methods.
+func ACR(amr []string) string {
+	for _, method := range amr {
+		if method == AMRMFA {
+			return ACRMFA
+		}
+	}
+	return ACRBasic
+}
+
+// ACRSatisfies reports whether acr is at least as strong as required.
+// Unknown classes satisfy nothing.
+func ACRSatisfies(acr, required string) bool {
+	levels := map[string]int{ACRBasic: 1, ACRMFA: 2}
+	return levels[acr] > 0 && levels[acr] >= levels[required]
+}
+
 type TokenDetails struct {
 	AccessToken  string
 	RefreshToken string
 	AccessUuid   string
 	RefreshUuid  string
+	FamilyID     string
 	AtExpires    int64
 	RtExpires    int64
+
+	// DeviceToken is only set when the login asked to remember the device
+	DeviceToken string `json:",omitempty"`
 }
 
-func GenerateToken(userID uint, cfg *config.Config) (*TokenDetails, error) {
-	td := &TokenDetails{}
-	td.AtExpires = time.Now().Add(time.Minute * 15).Unix() // 15 minutes
-	td.AccessUuid = "access-" + time.Now().String()        // In prod use proper UUID
+// NewID returns a random 128-bit identifier encoded as hex. It panics if
+// the system's secure random source fails, since no identifier can be
+// trusted then.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("utils: reading random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Grant describes what a token pair is issued for. ClientID and Scope are
+// only set for tokens issued to OAuth clients. AMR and AuthTime describe the
+// latest authentication of the session.
+type Grant struct {
+	UserID   uint
+	FamilyID string
+	ClientID string
+	Scope    string
+	AMR      []string
+	AuthTime time.Time
+}
 
-	td.RtExpires = time.Now().Add(time.Hour * 24 * 7).Unix() // 7 days
-	td.RefreshUuid = "refresh-" + time.Now().String()        // In prod use proper UUID
+// GenerateToken issues an access/refresh pair belonging to the refresh token
+// family of the grant.
+func GenerateToken(grant Grant, keys *TokenKeys) (*TokenDetails, error) {
+	td := &TokenDetails{FamilyID: grant.FamilyID}
+	td.AtExpires = time.Now().Add(AccessTokenTTL).Unix()
+	td.AccessUuid = "access-" + NewID()
+
+	td.RtExpires = time.Now().Add(RefreshTokenTTL).Unix()
+	td.RefreshUuid = "refresh-" + NewID()
 
 	// Access Token
 	atClaims := jwt.MapClaims{}
 	atClaims["authorized"] = true
 	atClaims["access_uuid"] = td.AccessUuid
-	atClaims["user_id"] = userID
+	atClaims["subject_type"] = SubjectUser
+	atClaims["user_id"] = grant.UserID
+	atClaims["family_id"] = grant.FamilyID
 	atClaims["exp"] = td.AtExpires
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
+	grant.setClientClaims(atClaims)
+	grant.setAuthClaims(atClaims)
 	var err error
-	td.AccessToken, err = at.SignedString([]byte(cfg.JWTSecret))
+	td.AccessToken, err = keys.Access.Sign(atClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -41,13 +118,106 @@ func GenerateToken(userID uint, cfg *config.Config) (*TokenDetails, error) {
 	// Refresh Token
 	rtClaims := jwt.MapClaims{}
 	rtClaims["refresh_uuid"] = td.RefreshUuid
-	rtClaims["user_id"] = userID
+	rtClaims["user_id"] = grant.UserID
+	rtClaims["family_id"] = grant.FamilyID
 	rtClaims["exp"] = td.RtExpires
-	rt := jwt.NewWithClaims(jwt.SigningMethodHS256, rtClaims)
-	td.RefreshToken, err = rt.SignedString([]byte(cfg.RefreshSecret))
+	grant.setClientClaims(rtClaims)
+	td.RefreshToken, err = keys.Refresh.Sign(rtClaims)
 	if err != nil {
 		return nil, err
 	}
 
 	return td, nil
 }
+
+// GenerateClientToken issues an access token whose subject is the client
+// itself. There is no refresh token; the client simply requests a new one.
+func GenerateClientToken(clientID, scope string, keys *TokenKeys) (*TokenDetails, error) {
+	td := &TokenDetails{}
+	td.AtExpires = time.Now().Add(AccessTokenTTL).Unix()
+	td.AccessUuid = "access-" + NewID()
+
+	atClaims := jwt.MapClaims{}
+	atClaims["authorized"] = true
+	atClaims["access_uuid"] = td.AccessUuid
+	atClaims["subject_type"] = SubjectClient
+	atClaims["sub"] = clientID
+	atClaims["exp"] = td.AtExpires
+	Grant{ClientID: clientID, Scope: scope}.setClientClaims(atClaims)
+
+	var err error
+	td.AccessToken, err = keys.Access.Sign(atClaims)
+	if err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+func (g Grant) setAuthClaims(claims jwt.MapClaims) {
+	if len(g.AMR) > 0 {
+		claims["amr"] = g.AMR
+		claims["acr"] = ACR(g.AMR)
+	}
+	if !g.AuthTime.IsZero() {
+		claims["auth_time"] = g.AuthTime.Unix()
+	}
+}
+
+func (g Grant) setClientClaims(claims jwt.MapClaims) {
+	if g.ClientID != "" {
+		claims["client_id"] = g.ClientID
+	}
+	if g.Scope != "" {
+		claims["scope"] = g.Scope
+	}
+}
+
+// Delegation describes a token issued through token exchange: the subject of
+// an existing access token, narrowed to one audience and scope, used by an
+// actor client on the subject's behalf.
+type Delegation struct {
+	Subject   jwt.MapClaims
+	ActorID   string
+	Audience  string
+	Scope     string
+	ExpiresAt time.Time
+}
+
+// GenerateDelegatedToken issues an access token for the subject of the
+// delegation. The act claim names the actor and nests any act claim of the
+// subject token, so chained delegation stays visible.
+func GenerateDelegatedToken(d Delegation, keys *TokenKeys) (*TokenDetails, error) {
+	td := &TokenDetails{FamilyID: stringClaim(d.Subject, "family_id")}
+	td.AtExpires = d.ExpiresAt.Unix()
+	td.AccessUuid = "access-" + NewID()
+
+	atClaims := jwt.MapClaims{}
+	atClaims["authorized"] = true
+	atClaims["access_uuid"] = td.AccessUuid
+	for _, claim := range []string{"subject_type", "user_id", "sub", "family_id", "amr", "acr", "auth_time"} {
+		if value, ok := d.Subject[claim]; ok {
+			atClaims[claim] = value
+		}
+	}
+	atClaims["aud"] = d.Audience
+	atClaims["exp"] = td.AtExpires
+
+	act := map[string]interface{}{"sub": d.ActorID}
+	if prior, ok := d.Subject["act"]; ok {
+		act["act"] = prior
+	}
+	atClaims["act"] = act
+	Grant{ClientID: d.ActorID, Scope: d.Scope}.setClientClaims(atClaims)
+
+	var err error
+	td.AccessToken, err = keys.Access.Sign(atClaims)
+	if err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
@@ -26,7 +26,7 @@ func ConnectPostgres(cfg *config.Config) {
 	log.Println("Connected to PostgreSQL")
 
 	// Auto Migrate
-	err = DB.AutoMigrate(&models.User{})
+	err = DB.AutoMigrate(&models.User{}, &models.Client{}, &models.TOTPCredential{}, &models.RecoveryCode{}, &models.WebAuthnCredential{}, &models.TrustedDevice{}, &models.PasswordHistory{})
 	if err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}